@@ -1,12 +1,16 @@
 package api_test
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -187,7 +191,972 @@ func TestV2_Records_GetSpecificVersion(t *testing.T) {
 	}
 }
 
+func TestV2_Records_Write(t *testing.T) {
+	router := newV1V2Router(t)
+
+	rr := doRequest(router, http.MethodPatch, "/api/v2/records/1", `{"hello":"world"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("patch missing status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodPost, "/api/v2/records/1?effective_from=2024-01-01T00:00:00Z", `{"hello":"world","gone":null}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var created entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	january := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	if created.ID != 1 || created.Version != 1 || created.CreatedAtMS == 0 || created.EffectiveFromMS != january {
+		t.Fatalf("unexpected version: %+v", created)
+	}
+	if _, ok := created.Data["gone"]; ok || created.Data["hello"] != "world" {
+		t.Fatalf("unexpected data: %+v", created.Data)
+	}
+
+	rr = doRequest(router, http.MethodPatch, "/api/v2/records/1?effective_from=2024-03-01T00:00:00Z", `{"hello":null,"status":"ok"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("patch status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var patched entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &patched); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if patched.Version != 2 || patched.Data["status"] != "ok" {
+		t.Fatalf("unexpected version: %+v", patched)
+	}
+	if _, ok := patched.Data["hello"]; ok {
+		t.Fatalf("expected hello to be deleted: %+v", patched)
+	}
+
+	// February, as we know it now, is still covered by version 1.
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1?valid_at=2024-02-01T00:00:00Z", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("valid_at status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var validAt entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &validAt); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if validAt.Version != 1 {
+		t.Fatalf("unexpected version: %+v", validAt)
+	}
+
+	rr = doRequest(router, http.MethodPost, "/api/v2/records/1?effective_from=yesterday", `{}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid effective_from status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2_Records_ConditionalWrite(t *testing.T) {
+	router := newV1V2Router(t)
+
+	rr := doRequest(router, http.MethodPost, "/api/v2/records/1", `{"hello":"world"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1", "")
+	etag := rr.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("unexpected etag %q", etag)
+	}
+
+	rr = doRequestWithHeaders(router, http.MethodPatch, "/api/v2/records/1", `{"hello":"first"}`, map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("first write status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("ETag"); got != `"2"` {
+		t.Fatalf("unexpected etag %q", got)
+	}
+
+	rr = doRequestWithHeaders(router, http.MethodPatch, "/api/v2/records/1", `{"hello":"second"}`, map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodPatch, "/api/v2/records/1?expected_version=1", `{"hello":"second"}`)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("stale expected_version status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequestWithHeaders(router, http.MethodPost, "/api/v2/records/2", `{"hello":"world"}`, map[string]string{"If-Match": "*"})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match * on missing record status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/versions/2", "")
+	if got := rr.Header().Get("ETag"); got != `"2"` {
+		t.Fatalf("unexpected etag %q", got)
+	}
+}
+
+func TestV2_Records_Diff(t *testing.T) {
+	router := newV1V2Router(t)
+
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"a":"1","b":"2"}`)
+	rr := doRequest(router, http.MethodPost, "/api/v2/records/1", `{"a":"one","b":null,"c":"3"}`)
+	var latest entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &latest); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	expected := entity.RecordDiff{
+		ID:          1,
+		FromVersion: 1,
+		ToVersion:   2,
+		Added:       map[string]interface{}{"c": "3"},
+		Removed:     map[string]interface{}{"b": "2"},
+		Changed:     map[string]entity.ValueChange{"a": {Old: "1", New: "one"}},
+	}
+
+	toAt := time.UnixMilli(latest.CreatedAtMS).UTC().Format(time.RFC3339Nano)
+	for _, path := range []string{
+		"/api/v2/records/1/diff?from=1&to=2",
+		"/api/v2/records/1/diff?from=1&to=" + url.QueryEscape(toAt),
+	} {
+		rr = doRequest(router, http.MethodGet, path, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", path, rr.Code, rr.Body.String())
+		}
+		var diff entity.RecordDiff
+		if err := json.Unmarshal(rr.Body.Bytes(), &diff); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if !reflect.DeepEqual(diff, expected) {
+			t.Fatalf("%s unexpected diff: %+v", path, diff)
+		}
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/diff?from=1&to=3", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("missing version status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/diff?from=1", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("missing to status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2_Records_ListVersionsWithChanges(t *testing.T) {
+	router := newV1V2Router(t)
+
+	_ = doRequest(router, http.MethodPost, "/api/v1/records/1", `{"hello":"world","status":"new"}`)
+	_ = doRequest(router, http.MethodPost, "/api/v1/records/1", `{"hello":null,"status":"ok"}`)
+
+	rr := doRequest(router, http.MethodGet, "/api/v2/records/1/versions", "")
+	var list entity.RecordVersions
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if list.Versions[1].Changes != nil {
+		t.Fatalf("expected changes to be omitted by default: %+v", list.Versions[1])
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/versions?include_changes=true", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(list.Versions[0].Changes) != 2 || list.Versions[0].Changes["hello"] != "world" {
+		t.Fatalf("unexpected v1 changes: %+v", list.Versions[0].Changes)
+	}
+	changes := list.Versions[1].Changes
+	if hello, ok := changes["hello"]; !ok || hello != nil {
+		t.Fatalf("expected hello to be recorded as nulled: %+v", changes)
+	}
+	if len(changes) != 2 || changes["status"] != "ok" {
+		t.Fatalf("unexpected v2 changes: %+v", changes)
+	}
+}
+
+func TestV1_Records_Delete(t *testing.T) {
+	router := newV1Router(t)
+
+	_ = doRequest(router, http.MethodPost, "/api/v1/records/1", `{"hello":"world"}`)
+
+	rr := doRequest(router, http.MethodDelete, "/api/v1/records/1", "")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("delete status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v1/records/1", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("get deleted status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodDelete, "/api/v1/records/1", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("delete deleted status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Posting to a deleted record creates it afresh.
+	rr = doRequest(router, http.MethodPost, "/api/v1/records/1", `{"status":"new"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("recreate status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var got entity.Record
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(got.Data) != 1 || got.Data["status"] != "new" {
+		t.Fatalf("unexpected record: %+v", got)
+	}
+}
+
+func TestV2_Records_DeleteAndRestore(t *testing.T) {
+	router := newV1V2Router(t)
+
+	rr := doRequest(router, http.MethodPost, "/api/v2/records/1", `{"hello":"world"}`)
+	var created entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	rr = doRequest(router, http.MethodPost, "/api/v2/records/1/restore", "")
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("restore live status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodDelete, "/api/v2/records/1", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var tombstone entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &tombstone); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if tombstone.Version != 2 || !tombstone.Deleted || len(tombstone.Data) != 0 {
+		t.Fatalf("unexpected tombstone: %+v", tombstone)
+	}
+
+	// A tombstone's empty data is still listed.
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/versions", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"version":2,`) || !strings.Contains(rr.Body.String(), `"data":{},"deleted":true`) {
+		t.Fatalf("versions status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("get deleted status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodPatch, "/api/v2/records/1", `{"hello":"again"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("patch deleted status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// The record is still visible as it was before it was deleted.
+	beforeDelete := time.UnixMilli(created.CreatedAtMS).UTC().Format(time.RFC3339Nano)
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1?at="+url.QueryEscape(beforeDelete), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("get before delete status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodPost, "/api/v2/records/1/restore", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("restore status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var restored entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &restored); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if restored.Version != 3 || restored.Deleted || restored.Data["hello"] != "world" {
+		t.Fatalf("unexpected restored version: %+v", restored)
+	}
+	if restored.RevertedToVersion == nil || *restored.RevertedToVersion != 1 {
+		t.Fatalf("expected restore to point at version 1: %+v", restored)
+	}
+}
+
+func TestV2_Records_ActorAndReason(t *testing.T) {
+	router := newV1V2Router(t)
+
+	headers := map[string]string{"X-Actor": "agent@example.com", "X-Change-Reason": "agent phone call"}
+	rr := doRequestWithHeaders(router, http.MethodPost, "/api/v2/records/1", `{"hello":"world"}`, headers)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	_ = doRequest(router, http.MethodPost, "/api/v1/records/1", `{"hello":"world 2"}`)
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/versions/1", "")
+	var version entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &version); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if version.Actor != "agent@example.com" || version.Reason != "agent phone call" {
+		t.Fatalf("unexpected version: %+v", version)
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/versions", "")
+	var list entity.RecordVersions
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if list.Versions[0].Actor != "agent@example.com" || list.Versions[0].Reason != "agent phone call" {
+		t.Fatalf("unexpected v1 metadata: %+v", list.Versions[0])
+	}
+	if list.Versions[1].Actor != "" || list.Versions[1].Reason != "" {
+		t.Fatalf("unexpected v2 metadata: %+v", list.Versions[1])
+	}
+}
+
+func TestV1_Records_ActorAndReason(t *testing.T) {
+	router := newV1V2Router(t)
+
+	headers := map[string]string{"X-Actor": "agent@example.com", "X-Change-Reason": "agent phone call"}
+	rr := doRequestWithHeaders(router, http.MethodPost, "/api/v1/records/1", `{"hello":"world","gone":null}`, headers)
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"id":1,"data":{"hello":"world"}}` {
+		t.Fatalf("create status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	_ = doRequestWithHeaders(router, http.MethodPost, "/api/v1/records/1", `{"hello":"world 2"}`, map[string]string{"X-Actor": "portal"})
+	rr = doRequestWithHeaders(router, http.MethodDelete, "/api/v1/records/1", "", map[string]string{"X-Change-Reason": "policy cancelled"})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("delete status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/versions?include_changes=true", "")
+	var list entity.RecordVersions
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(list.Versions) != 3 {
+		t.Fatalf("unexpected versions: %+v", list)
+	}
+	if v1 := list.Versions[0]; v1.Actor != "agent@example.com" || v1.Reason != "agent phone call" {
+		t.Fatalf("unexpected v1 metadata: %+v", v1)
+	}
+	if gone, ok := list.Versions[0].Changes["gone"]; !ok || gone != nil {
+		t.Fatalf("expected the nulled key in the change set: %+v", list.Versions[0].Changes)
+	}
+	if v2 := list.Versions[1]; v2.Actor != "portal" || v2.Reason != "" {
+		t.Fatalf("unexpected v2 metadata: %+v", v2)
+	}
+	if v3 := list.Versions[2]; !v3.Deleted || v3.Actor != "" || v3.Reason != "policy cancelled" {
+		t.Fatalf("unexpected v3 metadata: %+v", v3)
+	}
+}
+
+func TestV2_Records_List(t *testing.T) {
+	router := newV1V2Router(t)
+
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"state":"CA"}`)
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/2", `{"state":"NY"}`)
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/3", `{"state":"CA","size":"small"}`)
+	rr := doRequest(router, http.MethodPost, "/api/v2/records/4", `{"state":"CA"}`)
+	var fourth entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &fourth); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	// Later writes only touch record 4. A record's versions are always
+	// recorded after its previous one, so none of them can share the
+	// millisecond the past list is taken at.
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/4", `{"state":"NV"}`)
+
+	list := func(path string) entity.RecordList {
+		t.Helper()
+		rr := doRequest(router, http.MethodGet, path, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", path, rr.Code, rr.Body.String())
+		}
+		var list entity.RecordList
+		if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		return list
+	}
+
+	all := list("/api/v2/records?limit=2")
+	if len(all.Records) != 2 || all.Records[0].ID != 1 || all.NextCursor == nil || *all.NextCursor != 2 {
+		t.Fatalf("unexpected first page: %+v", all)
+	}
+	next := list("/api/v2/records?limit=2&after_id=2")
+	if len(next.Records) != 2 || next.Records[0].ID != 3 || next.NextCursor != nil {
+		t.Fatalf("unexpected second page: %+v", next)
+	}
+	if next.Records[1].Version != 2 || next.Records[1].Data["state"] != "NV" {
+		t.Fatalf("expected the latest version: %+v", next.Records[1])
+	}
+
+	california := list("/api/v2/records?where=state:CA")
+	if len(california.Records) != 2 || california.Records[0].ID != 1 || california.Records[1].ID != 3 {
+		t.Fatalf("unexpected filtered list: %+v", california)
+	}
+
+	at := time.UnixMilli(fourth.CreatedAtMS).UTC().Format(time.RFC3339Nano)
+	past := list("/api/v2/records?where=state:CA&at=" + url.QueryEscape(at))
+	if len(past.Records) != 3 || past.Records[0].ID != 1 || past.Records[2].ID != 4 {
+		t.Fatalf("unexpected past list: %+v", past)
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records?where=state", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid where status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2_Snapshot(t *testing.T) {
+	router := newV1V2Router(t)
+
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"state":"CA"}`)
+	rr := doRequest(router, http.MethodPost, "/api/v2/records/2", `{"state":"NY"}`)
+	var second entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &second); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	// Later writes only touch record 2, whose versions are always recorded
+	// after the one the snapshot is taken at.
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/2", `{"state":"TX"}`)
+	_ = doRequest(router, http.MethodDelete, "/api/v2/records/2", "")
+
+	at := time.UnixMilli(second.CreatedAtMS).UTC().Format(time.RFC3339Nano)
+	rr = doRequest(router, http.MethodGet, "/api/v2/snapshot?at="+url.QueryEscape(at), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+
+	var snapshot []entity.RecordVersion
+	decoder := json.NewDecoder(rr.Body)
+	for decoder.More() {
+		var recordVersion entity.RecordVersion
+		if err := decoder.Decode(&recordVersion); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		snapshot = append(snapshot, recordVersion)
+	}
+	if len(snapshot) != 2 || snapshot[0].Data["state"] != "CA" || snapshot[1].Data["state"] != "NY" {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/snapshot?at=1970-01-01T00:00:00Z", "")
+	if rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+		t.Fatalf("empty snapshot status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2_Records_TypedValues(t *testing.T) {
+	router := newV1V2Router(t)
+
+	rr := doRequest(router, http.MethodPost, "/api/v2/records/1", `{"name":"Acme","employees":12,"limit":1000000.50,"active":true,"address":{"state":"CA"},"tags":["a","b"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1", "")
+	var raw struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	for key, want := range map[string]string{
+		"name":      `"Acme"`,
+		"employees": `12`,
+		"limit":     `1000000.50`,
+		"active":    `true`,
+		"address":   `{"state":"CA"}`,
+		"tags":      `["a","b"]`,
+	} {
+		if got := string(raw.Data[key]); got != want {
+			t.Fatalf("%s: got %s, want %s", key, got, want)
+		}
+	}
+
+	// v1 keeps serving strings.
+	rr = doRequest(router, http.MethodGet, "/api/v1/records/1", "")
+	var record entity.Record
+	if err := json.Unmarshal(rr.Body.Bytes(), &record); err != nil {
+		t.Fatalf("unmarshal v1: %v", err)
+	}
+	if record.Data["name"] != "Acme" || record.Data["employees"] != "12" || record.Data["active"] != "true" || record.Data["address"] != `{"state":"CA"}` {
+		t.Fatalf("unexpected v1 record: %+v", record)
+	}
+	rr = doRequest(router, http.MethodPost, "/api/v1/records/1", `{"employees":13}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("v1 typed write status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records?where=employees:12", "")
+	var list entity.RecordList
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("unmarshal list: %v", err)
+	}
+	if len(list.Records) != 1 {
+		t.Fatalf("expected typed where to match: %+v", list)
+	}
+
+	rr = doRequest(router, http.MethodPatch, "/api/v2/records/1", `{"employees":12,"active":false}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("patch status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/diff?from=1&to=2", "")
+	var diff entity.RecordDiff
+	if err := json.Unmarshal(rr.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("unmarshal diff: %v", err)
+	}
+	if len(diff.Changed) != 1 || diff.Changed["active"].Old != true || diff.Changed["active"].New != false {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+}
+
+func TestV2_Records_Schema(t *testing.T) {
+	router := newV1V2Router(t)
+
+	rr := doRequest(router, http.MethodPost, "/api/v2/schemas/policy", `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"liability_limit": {"type": "number", "minimum": 0}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("register status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var schema entity.Schema
+	if err := json.Unmarshal(rr.Body.Bytes(), &schema); err != nil {
+		t.Fatalf("unmarshal schema: %v", err)
+	}
+	if schema.Name != "policy" || schema.Version != 1 {
+		t.Fatalf("unexpected schema: %+v", schema)
+	}
+
+	rr = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"name":"Acme"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodPut, "/api/v2/records/1/schema", `{"name":"policy"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("assign status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// A typo'd key is rejected instead of silently stored.
+	rr = doRequest(router, http.MethodPatch, "/api/v2/records/1", `{"liabilty_limit":1000}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("typo status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var errBody struct {
+		Error  string              `json:"error"`
+		Fields []entity.FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &errBody); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if len(errBody.Fields) != 1 || !strings.Contains(errBody.Fields[0].Message, "liabilty_limit") {
+		t.Fatalf("unexpected field errors: %+v", errBody)
+	}
+
+	rr = doRequest(router, http.MethodPatch, "/api/v2/records/1", `{"liability_limit":-5}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("minimum status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	errBody.Fields = nil
+	if err := json.Unmarshal(rr.Body.Bytes(), &errBody); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if len(errBody.Fields) != 1 || errBody.Fields[0].Field != "/liability_limit" {
+		t.Fatalf("unexpected field errors: %+v", errBody)
+	}
+
+	// Removing a required key through v1 fails the same way.
+	rr = doRequest(router, http.MethodPost, "/api/v1/records/1", `{"name":null}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("v1 status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodPatch, "/api/v2/records/1", `{"liability_limit":1000}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("valid patch status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/versions", "")
+	var versions entity.RecordVersions
+	if err := json.Unmarshal(rr.Body.Bytes(), &versions); err != nil {
+		t.Fatalf("unmarshal versions: %v", err)
+	}
+	if len(versions.Versions) != 2 {
+		t.Fatalf("rejected writes should not add versions: %+v", versions)
+	}
+}
+
+func TestV2_Collections(t *testing.T) {
+	router := newV1V2Router(t)
+
+	rr := doRequest(router, http.MethodPost, "/api/v2/vehicles/1", `{"make":"Volvo"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown collection status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodPut, "/api/v2/collections/snapshot", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("reserved name status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodPut, "/api/v2/collections/vehicles", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create collection status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodPost, "/api/v2/vehicles/1", `{"make":"Volvo"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create vehicle status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodPost, "/api/v1/records/1", `{"name":"Acme"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create v1 record status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// The same id in another collection is a different record.
+	rr = doRequest(router, http.MethodGet, "/api/v2/vehicles/1", "")
+	var vehicle entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &vehicle); err != nil {
+		t.Fatalf("unmarshal vehicle: %v", err)
+	}
+	if vehicle.Version != 1 || vehicle.Data["make"] != "Volvo" || vehicle.Data["name"] != nil {
+		t.Fatalf("unexpected vehicle: %+v", vehicle)
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1", "")
+	var record entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &record); err != nil {
+		t.Fatalf("unmarshal record: %v", err)
+	}
+	if record.Version != 1 || record.Data["name"] != "Acme" || record.Data["make"] != nil {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/collections", "")
+	var collections []entity.Collection
+	if err := json.Unmarshal(rr.Body.Bytes(), &collections); err != nil {
+		t.Fatalf("unmarshal collections: %v", err)
+	}
+	if len(collections) != 2 || collections[0].Name != "records" || collections[1].Name != "vehicles" {
+		t.Fatalf("unexpected collections: %+v", collections)
+	}
+}
+
+func TestV2_Records_Links(t *testing.T) {
+	router := newV1V2Router(t)
+
+	_ = doRequest(router, http.MethodPut, "/api/v2/collections/locations", "")
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"policy":"P-1"}`)
+	_ = doRequest(router, http.MethodPost, "/api/v2/locations/3", `{"city":"Austin"}`)
+
+	rr := doRequest(router, http.MethodPut, "/api/v2/records/1/links/location/locations/4", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("missing target status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodPut, "/api/v2/records/1/links/location/locations/3", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("link status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/expanded", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expanded status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var expanded entity.ExpandedRecord
+	if err := json.Unmarshal(rr.Body.Bytes(), &expanded); err != nil {
+		t.Fatalf("unmarshal expanded: %v", err)
+	}
+	if len(expanded.Links) != 1 || expanded.Links[0].Type != "location" || expanded.Links[0].Record == nil || expanded.Links[0].Record.Data["city"] != "Austin" {
+		t.Fatalf("unexpected expansion: %+v", expanded)
+	}
+
+	rr = doRequest(router, http.MethodDelete, "/api/v2/records/1/links/location/locations/3", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unlink status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/links", "")
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Fatalf("links status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2_Transactions(t *testing.T) {
+	router := newV1V2Router(t)
+
+	_ = doRequest(router, http.MethodPut, "/api/v2/collections/locations", "")
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"policy":"P-1"}`)
+
+	rr := doRequest(router, http.MethodPost, "/api/v2/transactions", `{"operations":[
+		{"op":"update","id":1,"data":{"status":"active"},"expected_version":1},
+		{"op":"create","collection":"locations","id":1,"data":{"city":"Austin"}},
+		{"op":"create","collection":"locations","id":2,"data":{"city":"Boston"}}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("transaction status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var transaction entity.Transaction
+	if err := json.Unmarshal(rr.Body.Bytes(), &transaction); err != nil {
+		t.Fatalf("unmarshal transaction: %v", err)
+	}
+	if len(transaction.Versions) != 3 {
+		t.Fatalf("unexpected transaction: %+v", transaction)
+	}
+	for _, version := range transaction.Versions {
+		if version.CreatedAtMS != transaction.CreatedAtMS || version.TransactionID != transaction.ID {
+			t.Fatalf("version not grouped with its transaction: %+v", version)
+		}
+	}
+	if transaction.Versions[1].Collection != "locations" || transaction.Versions[1].Data["city"] != "Austin" {
+		t.Fatalf("unexpected location version: %+v", transaction.Versions[1])
+	}
+
+	// A failing operation leaves every record untouched.
+	rr = doRequest(router, http.MethodPost, "/api/v2/transactions", `{"operations":[
+		{"op":"update","id":1,"data":{"status":"cancelled"}},
+		{"op":"delete","collection":"locations","id":1},
+		{"op":"update","collection":"locations","id":2,"data":{"city":"Chicago"},"expected_version":5}
+	]}`)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "operation 2") {
+		t.Fatalf("failed transaction status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1", "")
+	var policy entity.RecordVersion
+	if err := json.Unmarshal(rr.Body.Bytes(), &policy); err != nil {
+		t.Fatalf("unmarshal policy: %v", err)
+	}
+	if policy.Version != 2 || policy.Data["status"] != "active" {
+		t.Fatalf("policy changed by a failed transaction: %+v", policy)
+	}
+
+	rr = doRequest(router, http.MethodGet, fmt.Sprintf("/api/v2/transactions/%d", transaction.ID), "")
+	var stored entity.Transaction
+	if err := json.Unmarshal(rr.Body.Bytes(), &stored); err != nil {
+		t.Fatalf("unmarshal stored transaction: %v", err)
+	}
+	if !reflect.DeepEqual(stored, transaction) {
+		t.Fatalf("stored transaction differs:\n got %+v\nwant %+v", stored, transaction)
+	}
+}
+
+func TestV2_Export(t *testing.T) {
+	router := newV1V2Router(t)
+
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"state":"CA"}`)
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"state":"NV"}`)
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/2", `{"state":"NY"}`)
+
+	rr := doRequest(router, http.MethodGet, "/api/v2/export?format=csv&from_id=1&to_id=1", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/csv") {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], ",data.state") || !strings.HasSuffix(lines[2], ",NV") {
+		t.Fatalf("unexpected csv export: %s", rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/export", "")
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "\n") != 3 {
+		t.Fatalf("ndjson export status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/export?format=xml", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid format status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2_Changes(t *testing.T) {
+	server := httptest.NewUnstartedServer(newV1V2Router(t))
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+
+	post := func(path, body string) {
+		t.Helper()
+		resp, err := http.Post(server.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s status=%d", path, resp.StatusCode)
+		}
+	}
+	// open starts a change feed and returns a function reading its next event.
+	open := func(lastEventID string) (func() (string, entity.Change), func()) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v2/changes", nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET /changes: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("status=%d content type=%q", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		reader := bufio.NewReader(resp.Body)
+		next := func() (string, entity.Change) {
+			t.Helper()
+			var id string
+			var change entity.Change
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					t.Fatalf("reading event: %v", err)
+				}
+				line = strings.TrimRight(line, "\n")
+				switch {
+				case line == "":
+					return id, change
+				case strings.HasPrefix(line, "id: "):
+					id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "data: "):
+					if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &change); err != nil {
+						t.Fatalf("unmarshal: %v", err)
+					}
+				}
+			}
+		}
+		return next, func() { _ = resp.Body.Close() }
+	}
+
+	post("/api/v2/records/1", `{"state":"CA"}`)
+	post("/api/v2/records/1", `{"state":"NV"}`)
+
+	next, closeFeed := open("")
+	id, change := next()
+	if id != "1" || change.Seq != 1 || change.Collection != "records" || change.ID != 1 || change.Version != 1 {
+		t.Fatalf("unexpected first change %s: %+v", id, change)
+	}
+	_, _ = next()
+
+	// Writes made while the feed is open arrive without reconnecting, even
+	// after the server's write timeout.
+	time.Sleep(200 * time.Millisecond)
+	post("/api/v2/records/2", `{"state":"NY"}`)
+	id, change = next()
+	if id != "3" || change.ID != 2 || change.Data["state"] != "NY" {
+		t.Fatalf("unexpected live change %s: %+v", id, change)
+	}
+	closeFeed()
+
+	// Reconnecting with Last-Event-ID resumes right after it.
+	post("/api/v2/records/1", `{"state":"TX"}`)
+	next, closeFeed = open("2")
+	defer closeFeed()
+	id, _ = next()
+	if id != "3" {
+		t.Fatalf("expected to resume at 3, got %s", id)
+	}
+	id, change = next()
+	if id != "4" || change.ID != 1 || change.Version != 3 {
+		t.Fatalf("unexpected resumed change %s: %+v", id, change)
+	}
+
+	rr := doRequest(newV1V2Router(t), http.MethodGet, "/api/v2/changes?after=-1", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid after status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2_Webhooks(t *testing.T) {
+	router := newV1V2Router(t)
+
+	rr := doRequest(router, http.MethodPost, "/api/v2/webhooks", `{"url":"http://203.0.113.9/hook","collection":"records"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var webhook entity.Webhook
+	if err := json.Unmarshal(rr.Body.Bytes(), &webhook); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if webhook.ID != 1 || webhook.Secret == "" {
+		t.Fatalf("unexpected webhook: %+v", webhook)
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/webhooks", "")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), webhook.Secret) {
+		t.Fatalf("list status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/webhooks/1/dead-letters", "")
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Fatalf("dead letters status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodPost, "/api/v2/webhooks", `{"url":"not a url"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid url status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodPost, "/api/v2/webhooks", `{"url":"http://169.254.169.254/latest/meta-data"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("link-local url status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(router, http.MethodDelete, "/api/v2/webhooks/1", "")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("delete status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/webhooks/1/dead-letters", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("deleted dead letters status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2_Records_Verify(t *testing.T) {
+	router := newV1V2Router(t)
+
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"state":"CA"}`)
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"state":"NV"}`)
+
+	rr := doRequest(router, http.MethodGet, "/api/v2/records/1/verify", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var verification entity.Verification
+	if err := json.Unmarshal(rr.Body.Bytes(), &verification); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !verification.Valid || verification.Checked != 2 || verification.Broken != nil {
+		t.Fatalf("unexpected verification: %+v", verification)
+	}
+
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/2/verify", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("missing record status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2_Checkpoints(t *testing.T) {
+	router := newV1V2Router(t)
+
+	_ = doRequest(router, http.MethodPost, "/api/v2/records/1", `{"state":"CA"}`)
+
+	// The test server has no signing key.
+	rr := doRequest(router, http.MethodPost, "/api/v2/checkpoints", "")
+	if rr.Code != http.StatusNotImplemented {
+		t.Fatalf("create status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/checkpoints", "")
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Fatalf("list status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/versions/1/proof", "")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "checkpoint does not exist") {
+		t.Fatalf("proof status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(router, http.MethodGet, "/api/v2/records/1/versions/2/proof", "")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "record/version does not exist") {
+		t.Fatalf("missing version status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
 func doRequest(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	return doRequestWithHeaders(router, method, path, body, nil)
+}
+
+func doRequestWithHeaders(router http.Handler, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
 	rr := httptest.NewRecorder()
 	var req *http.Request
 	if body == "" {
@@ -196,6 +1165,9 @@ func doRequest(router http.Handler, method, path, body string) *httptest.Respons
 		req = httptest.NewRequest(method, path, bytes.NewBufferString(body))
 		req.Header.Set("Content-Type", "application/json")
 	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 	router.ServeHTTP(rr, req)
 	return rr
 }
@@ -6,10 +6,10 @@ import (
 )
 
 type API struct {
-	records service.RecordService
+	records service.VersionedRecordService
 }
 
-func NewAPI(records service.RecordService) *API {
+func NewAPI(records service.VersionedRecordService) *API {
 	return &API{records}
 }
 
@@ -17,4 +17,5 @@ func NewAPI(records service.RecordService) *API {
 func (a *API) CreateRoutes(routes *mux.Router) {
 	routes.Path("/records/{id}").HandlerFunc(a.GetRecords).Methods("GET")
 	routes.Path("/records/{id}").HandlerFunc(a.PostRecords).Methods("POST")
+	routes.Path("/records/{id}").HandlerFunc(a.DeleteRecords).Methods("DELETE")
 }
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"errors"
+	"net/http"
+
 	"github.com/gorilla/mux"
 	"github.com/rainbowmga/timetravel/service"
 )
@@ -14,7 +17,76 @@ func NewV2API(records service.VersionedRecordService) *V2API {
 }
 
 func (a *V2API) CreateRoutes(routes *mux.Router) {
-	routes.Path("/records/{id}").HandlerFunc(a.GetRecordLatest).Methods("GET")
-	routes.Path("/records/{id}/versions").HandlerFunc(a.ListRecordVersions).Methods("GET")
-	routes.Path("/records/{id}/versions/{version}").HandlerFunc(a.GetRecordVersion).Methods("GET")
+	routes.Path("/snapshot").HandlerFunc(a.GetSnapshot).Methods("GET")
+	routes.Path("/schemas/{name}").HandlerFunc(a.GetSchema).Methods("GET")
+	routes.Path("/schemas/{name}").HandlerFunc(a.PostSchema).Methods("POST")
+	routes.Path("/schemas/{name}/versions/{version}").HandlerFunc(a.GetSchema).Methods("GET")
+	routes.Path("/collections").HandlerFunc(a.ListCollections).Methods("GET")
+	routes.Path("/collections/{name}").HandlerFunc(a.PutCollection).Methods("PUT")
+	routes.Path("/checkpoints").HandlerFunc(a.ListCheckpoints).Methods("GET")
+	routes.Path("/checkpoints").HandlerFunc(a.PostCheckpoint).Methods("POST")
+	routes.Path("/changes").HandlerFunc(a.GetChanges).Methods("GET")
+	routes.Path("/export").HandlerFunc(a.GetExport).Methods("GET")
+	routes.Path("/import").HandlerFunc(a.PostImport).Methods("POST")
+	routes.Path("/webhooks").HandlerFunc(a.ListWebhooks).Methods("GET")
+	routes.Path("/webhooks").HandlerFunc(a.PostWebhook).Methods("POST")
+	routes.Path("/webhooks/{id}").HandlerFunc(a.DeleteWebhook).Methods("DELETE")
+	routes.Path("/webhooks/{id}/dead-letters").HandlerFunc(a.ListWebhookDeadLetters).Methods("GET")
+	routes.Path("/transactions").HandlerFunc(a.PostTransaction).Methods("POST")
+	routes.Path("/transactions/{id}").HandlerFunc(a.GetTransaction).Methods("GET")
+
+	// Every collection, including the default "records", is served under its name.
+	routes.Path("/{collection}").HandlerFunc(a.ListRecords).Methods("GET")
+	routes.Path("/{collection}/{id}").HandlerFunc(a.GetRecordLatest).Methods("GET")
+	routes.Path("/{collection}/{id}").HandlerFunc(a.PostRecordVersion).Methods("POST")
+	routes.Path("/{collection}/{id}").HandlerFunc(a.PatchRecordVersion).Methods("PATCH")
+	routes.Path("/{collection}/{id}").HandlerFunc(a.DeleteRecordVersion).Methods("DELETE")
+	routes.Path("/{collection}/{id}/restore").HandlerFunc(a.RestoreRecordVersion).Methods("POST")
+	routes.Path("/{collection}/{id}/diff").HandlerFunc(a.GetRecordDiff).Methods("GET")
+	routes.Path("/{collection}/{id}/fields/{key}/history").HandlerFunc(a.GetFieldHistory).Methods("GET")
+	routes.Path("/{collection}/{id}/versions").HandlerFunc(a.ListRecordVersions).Methods("GET")
+	routes.Path("/{collection}/{id}/versions/{version}").HandlerFunc(a.GetRecordVersion).Methods("GET")
+	routes.Path("/{collection}/{id}/versions/{version}/proof").HandlerFunc(a.GetRecordVersionProof).Methods("GET")
+	routes.Path("/{collection}/{id}/versions/{version}/revert").HandlerFunc(a.RevertRecordVersion).Methods("POST")
+	routes.Path("/{collection}/{id}/schema").HandlerFunc(a.GetRecordSchema).Methods("GET")
+	routes.Path("/{collection}/{id}/schema").HandlerFunc(a.PutRecordSchema).Methods("PUT")
+	routes.Path("/{collection}/{id}/links").HandlerFunc(a.ListRecordLinks).Methods("GET")
+	routes.Path("/{collection}/{id}/links/{type}/{target_collection}/{target_id}").HandlerFunc(a.PutRecordLink).Methods("PUT")
+	routes.Path("/{collection}/{id}/links/{type}/{target_collection}/{target_id}").HandlerFunc(a.DeleteRecordLink).Methods("DELETE")
+	routes.Path("/{collection}/{id}/expanded").HandlerFunc(a.GetRecordExpanded).Methods("GET")
+	routes.Path("/{collection}/{id}/verify").HandlerFunc(a.GetRecordVerify).Methods("GET")
+}
+
+// collection returns the records of the request's {collection}, writing an
+// error response if there is no such collection.
+func (a *V2API) collection(w http.ResponseWriter, r *http.Request) (service.VersionedRecordService, bool) {
+	return a.namedCollection(w, r, mux.Vars(r)["collection"])
+}
+
+// namedCollection is collection for a name taken from elsewhere in the
+// request; an empty name is the default collection.
+func (a *V2API) namedCollection(w http.ResponseWriter, r *http.Request, name string) (service.VersionedRecordService, bool) {
+	if name == "" || name == service.DefaultCollection {
+		return a.records, true
+	}
+
+	err := service.ErrCollectionDoesNotExist
+	if collections, ok := a.records.(service.CollectionService); ok {
+		var records service.VersionedRecordService
+		records, err = collections.Collection(r.Context(), name)
+		if err == nil {
+			return records, true
+		}
+	}
+
+	statusCode := http.StatusInternalServerError
+	message := ErrInternal.Error()
+	if errors.Is(err, service.ErrCollectionDoesNotExist) {
+		statusCode = http.StatusBadRequest
+		message = "collection does not exist"
+	}
+	errInWriting := writeError(w, message, statusCode)
+	logError(err)
+	logError(errInWriting)
+	return nil, false
 }
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// DELETE /{collection}/{id}
+// appends a tombstone version; the record's history stays readable.
+// Responds with the tombstone that was written.
+func (a *V2API) DeleteRecordVersion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	opts, err := writeOptionsFromRequest(r)
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	recordVersion, err := records.DeleteRecordVersion(ctx, int(idNumber), opts)
+	if err != nil {
+		writeVersionWriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(recordVersion.Version))
+	err = writeJSON(w, recordVersion, http.StatusOK)
+	logError(err)
+}
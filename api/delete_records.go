@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// DELETE /records/{id}
+// DeleteRecords deletes the record. Its history is kept.
+func (a *API) DeleteRecords(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	_, err = a.records.DeleteRecordVersion(ctx, int(idNumber), auditOptionsFromRequest(r))
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// DELETE /webhooks/{id}
+// unsubscribes a webhook and drops every delivery still queued for it.
+func (a *V2API) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	webhooks, ok := webhookService(w, a.records)
+	if !ok {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := webhooks.DeleteWebhook(ctx, id); err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrWebhookDoesNotExist) {
+			statusCode = http.StatusBadRequest
+			message = "webhook does not exist"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// changesPollInterval is how often an idle change feed checks for new versions.
+const changesPollInterval = 250 * time.Millisecond
+
+// changesKeepAliveInterval is how often an idle change feed sends a comment
+// so that proxies do not close the connection.
+const changesKeepAliveInterval = 15 * time.Second
+
+// GET /changes?collection=<name>&after=<seq>
+// streams every new version, in every collection or just one, as
+// Server-Sent Events. Each event is an entity.Change whose event id is its
+// sequence number; a client that reconnects with Last-Event-ID (or passes
+// after) resumes right after the last change it saw. Without either, the feed
+// starts at the beginning.
+func (a *V2API) GetChanges(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	feed, ok := a.records.(service.ChangeFeedService)
+	if !ok {
+		err := writeError(w, "change feeds are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err := writeError(w, "streaming is not supported", http.StatusInternalServerError)
+		logError(err)
+		return
+	}
+	liftWriteDeadline(w)
+
+	opts := service.ChangesOptions{Collection: r.URL.Query().Get("collection")}
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	if after != "" {
+		var err error
+		opts.AfterSeq, err = strconv.ParseInt(after, 10, 64)
+		if err != nil || opts.AfterSeq < 0 {
+			err := writeError(w, "invalid Last-Event-ID or after; must be a sequence number", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+	}
+
+	started := false
+	idleSince := time.Now()
+	for {
+		changes, err := feed.ListChanges(ctx, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// Once the stream has started the status can no longer change.
+			if !started {
+				statusCode := http.StatusInternalServerError
+				message := ErrInternal.Error()
+				if errors.Is(err, service.ErrCollectionDoesNotExist) {
+					statusCode = http.StatusBadRequest
+					message = "collection does not exist"
+				}
+				errInWriting := writeError(w, message, statusCode)
+				logError(errInWriting)
+			}
+			logError(err)
+			return
+		}
+
+		if !started {
+			w.Header().Add("Content-Type", "text/event-stream")
+			w.Header().Add("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			flusher.Flush()
+			started = true
+		}
+
+		for _, change := range changes {
+			data, err := json.Marshal(change)
+			if err != nil {
+				logError(err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", change.Seq, data); err != nil {
+				logError(err)
+				return
+			}
+			opts.AfterSeq = change.Seq
+		}
+		if len(changes) > 0 {
+			flusher.Flush()
+			idleSince = time.Now()
+		}
+		// A full page means there may be more waiting already.
+		if len(changes) == service.DefaultChangesLimit {
+			continue
+		}
+
+		if time.Since(idleSince) >= changesKeepAliveInterval {
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				logError(err)
+				return
+			}
+			flusher.Flush()
+			idleSince = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(changesPollInterval):
+		}
+	}
+}
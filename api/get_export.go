@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /export?collection=<name>&format=ndjson|csv&from_id=<n>&to_id=<n>&from=<RFC3339>&to=<RFC3339>
+// streams every version of every record in a collection (default: records)
+// with all of its metadata, ordered by id and version. from_id and to_id are
+// inclusive; from and to bound created_at_ms as [from, to). See
+// service.ExportOptions for both layouts.
+func (a *V2API) GetExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	records, ok := a.namedCollection(w, r, query.Get("collection"))
+	if !ok {
+		return
+	}
+	exporter, ok := records.(service.ExportService)
+	if !ok {
+		err := writeError(w, "exports are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+
+	opts := service.ExportOptions{Format: query.Get("format")}
+	if opts.Format == "" {
+		opts.Format = service.ExportFormatNDJSON
+	}
+	var err error
+	if value := query.Get("from_id"); value != "" {
+		if opts.FromID, err = strconv.Atoi(value); err != nil || opts.FromID <= 0 {
+			err := writeError(w, "invalid from_id; must be a positive number", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+	}
+	if value := query.Get("to_id"); value != "" {
+		if opts.ToID, err = strconv.Atoi(value); err != nil || opts.ToID <= 0 {
+			err := writeError(w, "invalid to_id; must be a positive number", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+	}
+	fromMS, ok, err := parseTimeParam(r, "from")
+	if err != nil {
+		err := writeError(w, "invalid from; must be an RFC3339 timestamp", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if ok {
+		opts.FromMS = &fromMS
+	}
+	toMS, ok, err := parseTimeParam(r, "to")
+	if err != nil {
+		err := writeError(w, "invalid to; must be an RFC3339 timestamp", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if ok {
+		opts.ToMS = &toMS
+	}
+
+	contentType := "application/x-ndjson"
+	if opts.Format == service.ExportFormatCSV {
+		contentType = "text/csv; charset=utf-8"
+	}
+	liftWriteDeadline(w)
+	stream := &streamWriter{w: w, contentType: contentType}
+	err = exporter.ExportRecords(ctx, stream, opts)
+	if err != nil {
+		// Once the stream has started the status can no longer change.
+		if !stream.started {
+			statusCode := http.StatusInternalServerError
+			message := ErrInternal.Error()
+			if errors.Is(err, service.ErrExportFormatInvalid) || errors.Is(err, service.ErrExportOptionsInvalid) {
+				statusCode = http.StatusBadRequest
+				message = err.Error()
+			}
+			errInWriting := writeError(w, message, statusCode)
+			logError(errInWriting)
+		}
+		logError(err)
+		return
+	}
+	if !stream.started {
+		stream.start()
+	}
+}
+
+// streamWriter starts a 200 response with the given content type on the
+// first write, so that errors found before anything is written can still be
+// reported with writeError.
+type streamWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+func (s *streamWriter) start() {
+	s.w.Header().Add("Content-Type", s.contentType)
+	s.w.WriteHeader(http.StatusOK)
+	s.started = true
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if !s.started {
+		s.start()
+	}
+	return s.w.Write(p)
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /{collection}/{id}/fields/{key}/history
+// lists every value {key} has had, including when it was removed.
+func (a *V2API) GetFieldHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	history, err := records.FieldHistory(ctx, int(idNumber), vars["key"])
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		switch {
+		case errors.Is(err, service.ErrRecordDoesNotExist):
+			statusCode = http.StatusBadRequest
+			message = "record does not exist"
+		case errors.Is(err, service.ErrDataKeyInvalid):
+			statusCode = http.StatusBadRequest
+			message = err.Error()
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, history, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+var errDiffBoundInvalid = errors.New("invalid from/to; must be a positive version number or an RFC3339 timestamp")
+
+// GET /{collection}/{id}/diff?from=<version|RFC3339>&to=<version|RFC3339>
+// timestamps are resolved to the version that was current at that time.
+func (a *V2API) GetRecordDiff(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	query := r.URL.Query()
+	fromVersion, err := resolveVersion(ctx, records, int(idNumber), query.Get("from"))
+	if err != nil {
+		writeDiffError(w, err)
+		return
+	}
+	toVersion, err := resolveVersion(ctx, records, int(idNumber), query.Get("to"))
+	if err != nil {
+		writeDiffError(w, err)
+		return
+	}
+
+	diff, err := records.DiffRecordVersions(ctx, int(idNumber), fromVersion, toVersion)
+	if err != nil {
+		writeDiffError(w, err)
+		return
+	}
+
+	err = writeJSON(w, diff, http.StatusOK)
+	logError(err)
+}
+
+func writeDiffError(w http.ResponseWriter, err error) {
+	statusCode := http.StatusInternalServerError
+	message := ErrInternal.Error()
+	switch {
+	case errors.Is(err, errDiffBoundInvalid):
+		statusCode = http.StatusBadRequest
+		message = err.Error()
+	case errors.Is(err, service.ErrRecordVersionDoesNotExist) || errors.Is(err, service.ErrRecordDoesNotExist):
+		statusCode = http.StatusBadRequest
+		message = "record/version does not exist"
+	}
+
+	errInWriting := writeError(w, message, statusCode)
+	logError(err)
+	logError(errInWriting)
+}
+
+// resolveVersion turns a diff bound into a version number, looking
+// timestamps up with GetRecordVersionAt.
+func resolveVersion(ctx context.Context, records service.VersionedRecordService, id int, bound string) (int, error) {
+	if version, err := strconv.ParseInt(bound, 10, 32); err == nil {
+		if version <= 0 {
+			return 0, errDiffBoundInvalid
+		}
+		return int(version), nil
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, bound)
+	if err != nil {
+		return 0, errDiffBoundInvalid
+	}
+	recordVersion, err := records.GetRecordVersionAt(ctx, id, at.UTC().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return recordVersion.Version, nil
+}
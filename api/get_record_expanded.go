@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /{collection}/{id}/expanded?at=<RFC3339>
+// returns the record as it was at `at` (default: now), with every record it
+// linked to at that time expanded as it was at that same time.
+func (a *V2API) GetRecordExpanded(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	links, ok := linkService(w, records)
+	if !ok {
+		return
+	}
+
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	atMS, ok, err := parseTimeParam(r, "at")
+	if err != nil {
+		err := writeError(w, "invalid at; must be an RFC3339 timestamp", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if !ok {
+		atMS = time.Now().UTC().UnixMilli()
+	}
+
+	expanded, err := links.ExpandRecord(ctx, int(idNumber), atMS)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrRecordDoesNotExist) {
+			statusCode = http.StatusBadRequest
+			message = "record does not exist"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, expanded, http.StatusOK)
+	logError(err)
+}
@@ -11,9 +11,13 @@ import (
 	"github.com/rainbowmga/timetravel/service"
 )
 
-// GET /records/{id}
+// GET /{collection}/{id}?at=<RFC3339>&valid_at=<RFC3339>
 func (a *V2API) GetRecordLatest(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
 	id := mux.Vars(r)["id"]
 
 	idNumber, err := strconv.ParseInt(id, 10, 32)
@@ -23,18 +27,33 @@ func (a *V2API) GetRecordLatest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	at := r.URL.Query().Get("at")
+	// `at` travels along transaction time (what we knew), `valid_at` along
+	// effective time (what was true). Without `valid_at`, `at` alone keeps
+	// its original meaning.
+	atMS, hasAt, parseErr := parseTimeParam(r, "at")
+	if parseErr != nil {
+		err := writeError(w, "invalid at; must be an RFC3339 timestamp", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	validAtMS, hasValidAt, parseErr := parseTimeParam(r, "valid_at")
+	if parseErr != nil {
+		err := writeError(w, "invalid valid_at; must be an RFC3339 timestamp", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
 	var recordVersion entity.RecordVersion
-	if at == "" {
-		recordVersion, err = a.records.GetLatestRecordVersion(ctx, int(idNumber))
-	} else {
-		atTime, parseErr := time.Parse(time.RFC3339Nano, at)
-		if parseErr != nil {
-			err := writeError(w, "invalid at; must be an RFC3339 timestamp", http.StatusBadRequest)
-			logError(err)
-			return
+	switch {
+	case hasValidAt:
+		if !hasAt {
+			atMS = time.Now().UTC().UnixMilli()
 		}
-		recordVersion, err = a.records.GetRecordVersionAt(ctx, int(idNumber), atTime.UTC().UnixMilli())
+		recordVersion, err = records.GetRecordVersionAsOf(ctx, int(idNumber), validAtMS, atMS)
+	case hasAt:
+		recordVersion, err = records.GetRecordVersionAt(ctx, int(idNumber), atMS)
+	default:
+		recordVersion, err = records.GetLatestRecordVersion(ctx, int(idNumber))
 	}
 	if err != nil {
 		statusCode := http.StatusInternalServerError
@@ -49,6 +68,7 @@ func (a *V2API) GetRecordLatest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(recordVersion.Version))
 	err = writeJSON(w, recordVersion, http.StatusOK)
 	logError(err)
 }
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /{collection}/{id}/verify
+// walks the hash chains through every version of a record and reports
+// whether its history is intact, or the first version where it is not.
+func (a *V2API) GetRecordVerify(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	verifier, ok := records.(service.VerifyService)
+	if !ok {
+		err := writeError(w, "verification is not supported by this store", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	verification, err := verifier.VerifyRecord(ctx, int(idNumber))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrRecordDoesNotExist) {
+			statusCode = http.StatusBadRequest
+			message = "record does not exist"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, verification, http.StatusOK)
+	logError(err)
+}
@@ -9,9 +9,13 @@ import (
 	"github.com/rainbowmga/timetravel/service"
 )
 
-// GET /records/{id}/versions/{version}
+// GET /{collection}/{id}/versions/{version}
 func (a *V2API) GetRecordVersion(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
 	vars := mux.Vars(r)
 
 	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
@@ -28,7 +32,7 @@ func (a *V2API) GetRecordVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	recordVersion, err := a.records.GetRecordVersion(ctx, int(idNumber), int(versionNumber))
+	recordVersion, err := records.GetRecordVersion(ctx, int(idNumber), int(versionNumber))
 	if err != nil {
 		statusCode := http.StatusInternalServerError
 		message := ErrInternal.Error()
@@ -42,6 +46,7 @@ func (a *V2API) GetRecordVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(recordVersion.Version))
 	err = writeJSON(w, recordVersion, http.StatusOK)
 	logError(err)
 }
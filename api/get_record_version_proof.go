@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /{collection}/{id}/versions/{version}/proof?checkpoint=<id>
+// returns the audit path proving a version is included in a checkpoint
+// (default: the latest), along with the signed checkpoint itself.
+func (a *V2API) GetRecordVersionProof(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	checkpoints, ok := checkpointService(w, records)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	versionNumber, err := strconv.ParseInt(vars["version"], 10, 32)
+	if err != nil || versionNumber <= 0 {
+		err := writeError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var checkpointID int64
+	if value := r.URL.Query().Get("checkpoint"); value != "" {
+		checkpointID, err = strconv.ParseInt(value, 10, 32)
+		if err != nil || checkpointID <= 0 {
+			err := writeError(w, "invalid checkpoint; must be a positive number", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+	}
+
+	proof, err := checkpoints.ProveVersion(ctx, int(idNumber), int(versionNumber), int(checkpointID))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		switch {
+		case errors.Is(err, service.ErrRecordVersionDoesNotExist):
+			statusCode = http.StatusBadRequest
+			message = "record/version does not exist"
+		case errors.Is(err, service.ErrCheckpointDoesNotExist):
+			statusCode = http.StatusBadRequest
+			message = "checkpoint does not exist"
+		case errors.Is(err, service.ErrVersionNotCheckpointed):
+			statusCode = http.StatusBadRequest
+			message = err.Error()
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, proof, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /schemas/{name}
+// GET /schemas/{name}/versions/{version}
+// returns a registered schema, by default its latest version.
+func (a *V2API) GetSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	schemas, ok := schemaService(w, a.records)
+	if !ok {
+		return
+	}
+
+	version := 0
+	if value, ok := vars["version"]; ok {
+		versionNumber, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || versionNumber <= 0 {
+			err := writeError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		version = int(versionNumber)
+	}
+
+	schema, err := schemas.GetSchema(ctx, vars["name"], version)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrSchemaDoesNotExist) {
+			statusCode = http.StatusBadRequest
+			message = "schema does not exist"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, schema, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// GET /snapshot?at=<RFC3339>&collection=<name>
+// streams every record of a collection (default: records) as it was at `at`
+// (default: now) as NDJSON, one entity.RecordVersion per line.
+func (a *V2API) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.namedCollection(w, r, r.URL.Query().Get("collection"))
+	if !ok {
+		return
+	}
+
+	atMS, ok, err := parseTimeParam(r, "at")
+	if err != nil {
+		err := writeError(w, "invalid at; must be an RFC3339 timestamp", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if !ok {
+		atMS = time.Now().UTC().UnixMilli()
+	}
+
+	started := false
+	encoder := json.NewEncoder(w)
+	err = records.SnapshotAt(ctx, atMS, func(recordVersion entity.RecordVersion) error {
+		if !started {
+			startNDJSON(w)
+			started = true
+		}
+		return encoder.Encode(recordVersion)
+	})
+	if err != nil {
+		// Once the stream has started the status can no longer change.
+		if !started {
+			errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+			logError(errInWriting)
+		}
+		logError(err)
+		return
+	}
+	if !started {
+		startNDJSON(w)
+	}
+}
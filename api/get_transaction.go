@@ -0,0 +1,47 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /transactions/{id}
+// returns a transaction and every version it wrote.
+func (a *V2API) GetTransaction(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	transactions, ok := a.records.(service.TransactionService)
+	if !ok {
+		err := writeError(w, "transactions are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	transaction, err := transactions.GetTransaction(ctx, int(idNumber))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrTransactionDoesNotExist) {
+			statusCode = http.StatusBadRequest
+			message = "transaction does not exist"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, transaction, http.StatusOK)
+	logError(err)
+}
@@ -3,8 +3,15 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
 )
 
 var (
@@ -26,12 +33,121 @@ func writeJSON(w http.ResponseWriter, data interface{}, statusCode int) error {
 	return err
 }
 
-// writeError writes the message as an error
-func writeError(w http.ResponseWriter, message string, statusCode int) error {
+// startNDJSON begins a newline-delimited JSON response.
+func startNDJSON(w http.ResponseWriter) {
+	w.Header().Add("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeDeadlineSetter is implemented by the server's ResponseWriter from Go 1.20.
+type writeDeadlineSetter interface {
+	SetWriteDeadline(deadline time.Time) error
+}
+
+// liftWriteDeadline lets a streaming response run past the server's
+// WriteTimeout, for as long as the client keeps reading.
+func liftWriteDeadline(w http.ResponseWriter) {
+	if setter, ok := w.(writeDeadlineSetter); ok {
+		logError(setter.SetWriteDeadline(time.Time{}))
+	}
+}
+
+// errorResponse is the body of every error response.
+type errorResponse struct {
+	Error  string              `json:"error"`
+	Fields []entity.FieldError `json:"fields,omitempty"`
+}
+
+// writeError writes the message as an error, along with any fields that caused it
+func writeError(w http.ResponseWriter, message string, statusCode int, fields ...entity.FieldError) error {
 	log.Printf("response errored: %s", message)
 	return writeJSON(
 		w,
-		map[string]string{"error": message},
+		errorResponse{Error: message, Fields: fields},
 		statusCode,
 	)
 }
+
+// parseTimeParam parses an optional RFC3339 query parameter into unix milliseconds.
+func parseTimeParam(r *http.Request, name string) (int64, bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, false, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, false, err
+	}
+	return t.UTC().UnixMilli(), true, nil
+}
+
+// parseBoolParam parses an optional boolean query parameter, defaulting to false.
+func parseBoolParam(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// auditOptionsFromRequest reads who made a change and why from the X-Actor
+// and X-Change-Reason headers. They are all a v1 write carries, so v1
+// requests otherwise behave as they always have.
+func auditOptionsFromRequest(r *http.Request) service.WriteOptions {
+	return service.WriteOptions{
+		Actor:  r.Header.Get("X-Actor"),
+		Reason: r.Header.Get("X-Change-Reason"),
+	}
+}
+
+// writeOptionsFromRequest reads the version metadata a v2 write may carry,
+// starting from auditOptionsFromRequest.
+func writeOptionsFromRequest(r *http.Request) (service.WriteOptions, error) {
+	opts := auditOptionsFromRequest(r)
+
+	effectiveFromMS, ok, err := parseTimeParam(r, "effective_from")
+	if err != nil {
+		return service.WriteOptions{}, errors.New("invalid effective_from; must be an RFC3339 timestamp")
+	}
+	if ok {
+		opts.EffectiveFromMS = &effectiveFromMS
+	}
+
+	effectiveToMS, ok, err := parseTimeParam(r, "effective_to")
+	if err != nil {
+		return service.WriteOptions{}, errors.New("invalid effective_to; must be an RFC3339 timestamp")
+	}
+	if ok {
+		opts.EffectiveToMS = &effectiveToMS
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != "*" {
+		expectedVersion, err := parseETag(ifMatch)
+		if err != nil {
+			return service.WriteOptions{}, errors.New("invalid If-Match; must be an ETag returned by this API")
+		}
+		opts.ExpectedVersion = &expectedVersion
+	} else if value := r.URL.Query().Get("expected_version"); value != "" {
+		expectedVersion, err := strconv.Atoi(value)
+		if err != nil || expectedVersion < 0 {
+			return service.WriteOptions{}, errors.New("invalid expected_version; must be a non-negative number")
+		}
+		opts.ExpectedVersion = &expectedVersion
+	}
+
+	return opts, nil
+}
+
+// versionETag derives a strong ETag from a record version.
+func versionETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseETag is the inverse of versionETag.
+func parseETag(etag string) (int, error) {
+	value := strings.TrimSpace(etag)
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return 0, fmt.Errorf("malformed etag %q", etag)
+	}
+	return strconv.Atoi(value[1 : len(value)-1])
+}
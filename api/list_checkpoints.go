@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+)
+
+// GET /checkpoints
+// lists every checkpoint, newest first.
+func (a *V2API) ListCheckpoints(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	checkpoints, ok := checkpointService(w, a.records)
+	if !ok {
+		return
+	}
+
+	list, err := checkpoints.ListCheckpoints(ctx)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, list, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /collections
+// lists every collection, including the default "records".
+func (a *V2API) ListCollections(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	collections, ok := a.records.(service.CollectionService)
+	if !ok {
+		err := writeError(w, "collections are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+
+	list, err := collections.ListCollections(ctx)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, list, http.StatusOK)
+	logError(err)
+}
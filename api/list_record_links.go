@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /{collection}/{id}/links?at=<RFC3339>
+// lists the record's links as they were at `at` (default: now).
+func (a *V2API) ListRecordLinks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	links, ok := linkService(w, records)
+	if !ok {
+		return
+	}
+
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	atMS, ok, err := parseTimeParam(r, "at")
+	if err != nil {
+		err := writeError(w, "invalid at; must be an RFC3339 timestamp", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if !ok {
+		atMS = time.Now().UTC().UnixMilli()
+	}
+
+	list, err := links.ListLinks(ctx, int(idNumber), atMS)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrRecordIDInvalid) {
+			statusCode = http.StatusBadRequest
+			message = err.Error()
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, list, http.StatusOK)
+	logError(err)
+}
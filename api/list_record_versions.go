@@ -9,9 +9,14 @@ import (
 	"github.com/rainbowmga/timetravel/service"
 )
 
-// GET /records/{id}/versions
+// GET /{collection}/{id}/versions?limit=<n>&after_version=<n>&order=asc|desc&from=<RFC3339>&to=<RFC3339>&include_changes=true&omit_data=true
+// versions are paged by passing the response's next_cursor as after_version.
 func (a *V2API) ListRecordVersions(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
 	id := mux.Vars(r)["id"]
 
 	idNumber, err := strconv.ParseInt(id, 10, 32)
@@ -21,7 +26,14 @@ func (a *V2API) ListRecordVersions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	versions, err := a.records.ListRecordVersions(ctx, int(idNumber))
+	opts, err := listVersionsOptionsFromRequest(r)
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	versions, err := records.ListRecordVersions(ctx, int(idNumber), opts)
 	if err != nil {
 		statusCode := http.StatusInternalServerError
 		message := ErrInternal.Error()
@@ -38,3 +50,52 @@ func (a *V2API) ListRecordVersions(w http.ResponseWriter, r *http.Request) {
 	err = writeJSON(w, versions, http.StatusOK)
 	logError(err)
 }
+
+func listVersionsOptionsFromRequest(r *http.Request) (service.ListVersionsOptions, error) {
+	var opts service.ListVersionsOptions
+	query := r.URL.Query()
+
+	var err error
+	if opts.IncludeChanges, err = parseBoolParam(r, "include_changes"); err != nil {
+		return opts, errors.New("invalid include_changes; must be true or false")
+	}
+	if opts.OmitData, err = parseBoolParam(r, "omit_data"); err != nil {
+		return opts, errors.New("invalid omit_data; must be true or false")
+	}
+
+	if value := query.Get("limit"); value != "" {
+		if opts.Limit, err = strconv.Atoi(value); err != nil || opts.Limit <= 0 {
+			return opts, errors.New("invalid limit; must be a positive number")
+		}
+	}
+	if value := query.Get("after_version"); value != "" {
+		if opts.AfterVersion, err = strconv.Atoi(value); err != nil || opts.AfterVersion <= 0 {
+			return opts, errors.New("invalid after_version; must be a positive number")
+		}
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, errors.New("invalid order; must be asc or desc")
+	}
+
+	fromMS, ok, err := parseTimeParam(r, "from")
+	if err != nil {
+		return opts, errors.New("invalid from; must be an RFC3339 timestamp")
+	}
+	if ok {
+		opts.FromMS = &fromMS
+	}
+	toMS, ok, err := parseTimeParam(r, "to")
+	if err != nil {
+		return opts, errors.New("invalid to; must be an RFC3339 timestamp")
+	}
+	if ok {
+		opts.ToMS = &toMS
+	}
+
+	return opts, nil
+}
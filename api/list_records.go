@@ -0,0 +1,85 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /{collection}?where=<key>:<value>&limit=<n>&after_id=<n>&at=<RFC3339>
+// lists records at their latest version, or as they were at `at`.
+// `where` may be repeated; records must match all of them.
+// records are paged by passing the response's next_cursor as after_id.
+func (a *V2API) ListRecords(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := listRecordsOptionsFromRequest(r)
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	list, err := records.ListRecords(ctx, opts)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrDataKeyInvalid) {
+			statusCode = http.StatusBadRequest
+			message = err.Error()
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, list, http.StatusOK)
+	logError(err)
+}
+
+func listRecordsOptionsFromRequest(r *http.Request) (service.ListRecordsOptions, error) {
+	var opts service.ListRecordsOptions
+	query := r.URL.Query()
+
+	var err error
+	if value := query.Get("limit"); value != "" {
+		if opts.Limit, err = strconv.Atoi(value); err != nil || opts.Limit <= 0 {
+			return opts, errors.New("invalid limit; must be a positive number")
+		}
+	}
+	if value := query.Get("after_id"); value != "" {
+		if opts.AfterID, err = strconv.Atoi(value); err != nil || opts.AfterID <= 0 {
+			return opts, errors.New("invalid after_id; must be a positive number")
+		}
+	}
+
+	for _, where := range query["where"] {
+		parts := strings.SplitN(where, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return opts, errors.New("invalid where; must be <key>:<value>")
+		}
+		if opts.Where == nil {
+			opts.Where = map[string]string{}
+		}
+		opts.Where[parts[0]] = parts[1]
+	}
+
+	atMS, ok, err := parseTimeParam(r, "at")
+	if err != nil {
+		return opts, errors.New("invalid at; must be an RFC3339 timestamp")
+	}
+	if ok {
+		opts.AtMS = &atMS
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /webhooks/{id}/dead-letters
+// lists the deliveries to a webhook that ran out of attempts, oldest first.
+// Each names the seq of its change, which can be read back from /changes.
+func (a *V2API) ListWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	webhooks, ok := webhookService(w, a.records)
+	if !ok {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := webhooks.ListDeadLetters(ctx, id)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrWebhookDoesNotExist) {
+			statusCode = http.StatusBadRequest
+			message = "webhook does not exist"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, deliveries, http.StatusOK)
+	logError(err)
+}
+
+// webhookID parses the {id} of a webhook route, writing a 400 if it is invalid.
+func webhookID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || id <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return 0, false
+	}
+	return int(id), true
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+)
+
+// GET /webhooks
+// lists every webhook, without its secret.
+func (a *V2API) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	webhooks, ok := webhookService(w, a.records)
+	if !ok {
+		return
+	}
+
+	list, err := webhooks.ListWebhooks(ctx)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, list, http.StatusOK)
+	logError(err)
+}
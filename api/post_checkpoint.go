@@ -0,0 +1,48 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// POST /checkpoints
+// signs a checkpoint of every version written so far. Checkpoints are also
+// made periodically when the server has a signing key.
+func (a *V2API) PostCheckpoint(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	checkpoints, ok := checkpointService(w, a.records)
+	if !ok {
+		return
+	}
+
+	checkpoint, err := checkpoints.CreateCheckpoint(ctx)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrCheckpointKeyMissing) {
+			statusCode = http.StatusNotImplemented
+			message = "no checkpoint signing key is configured"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, checkpoint, http.StatusOK)
+	logError(err)
+}
+
+// checkpointService returns the checkpoint support of records, writing a 501
+// if it has none.
+func checkpointService(w http.ResponseWriter, records service.VersionedRecordService) (service.CheckpointService, bool) {
+	checkpoints, ok := records.(service.CheckpointService)
+	if !ok {
+		err := writeError(w, "checkpoints are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+	}
+	return checkpoints, ok
+}
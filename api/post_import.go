@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// POST /import?collection=<name>&format=ndjson|csv&dry_run=true&batch_size=<n>&effective_from=<RFC3339>&effective_to=<RFC3339>
+// loads the records in the body into a collection (default: records), in
+// transactions of batch_size records. Each record is created, or has all of
+// its data replaced; the effective range applies to every version written. format defaults to csv for a text/csv body and ndjson
+// otherwise; see service.ImportOptions for both layouts.
+// Responds with a report listing every line that was not imported.
+func (a *V2API) PostImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	records, ok := a.namedCollection(w, r, query.Get("collection"))
+	if !ok {
+		return
+	}
+	importer, ok := records.(service.ImportService)
+	if !ok {
+		err := writeError(w, "imports are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+
+	writeOpts, err := writeOptionsFromRequest(r)
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	writeOpts.ExpectedVersion = nil
+
+	opts := service.ImportOptions{Format: query.Get("format"), WriteOptions: writeOpts}
+	if opts.Format == "" {
+		opts.Format = service.ImportFormatNDJSON
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			opts.Format = service.ImportFormatCSV
+		}
+	}
+	dryRun, err := parseBoolParam(r, "dry_run")
+	if err != nil {
+		err := writeError(w, "invalid dry_run; must be true or false", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	opts.DryRun = dryRun
+	if value := query.Get("batch_size"); value != "" {
+		batchSize, err := strconv.Atoi(value)
+		if err != nil || batchSize <= 0 {
+			err := writeError(w, "invalid batch_size; must be a positive number", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		opts.BatchSize = batchSize
+	}
+
+	report, err := importer.ImportRecords(ctx, r.Body, opts)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		switch {
+		case errors.Is(err, service.ErrImportFormatInvalid),
+			errors.Is(err, service.ErrImportHeaderInvalid):
+			statusCode = http.StatusBadRequest
+			message = err.Error()
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, report, http.StatusOK)
+	logError(err)
+}
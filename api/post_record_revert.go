@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// POST /{collection}/{id}/versions/{version}/revert
+// appends a new version whose data equals {version}'s.
+// Responds with the version that was written.
+func (a *V2API) RevertRecordVersion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	versionNumber, err := strconv.ParseInt(vars["version"], 10, 32)
+	if err != nil || versionNumber <= 0 {
+		err := writeError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	opts, err := writeOptionsFromRequest(r)
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	recordVersion, err := records.RevertRecord(ctx, int(idNumber), int(versionNumber), opts)
+	if err != nil {
+		writeVersionWriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(recordVersion.Version))
+	err = writeJSON(w, recordVersion, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// POST /{collection}/{id}?effective_from=<RFC3339>&effective_to=<RFC3339>&expected_version=<n>
+// if the record exists, a new version is appended.
+// if the record doesn't exist, the record is created at version 1.
+// Unlike v1, values may be any JSON value; null deletes the key.
+// Responds with the version that was written.
+//
+// Writes can be made conditional with an If-Match header holding the ETag of
+// the version they were based on (412 on mismatch), or with expected_version
+// (409 on mismatch). `If-Match: *` only updates an existing record.
+func (a *V2API) PostRecordVersion(w http.ResponseWriter, r *http.Request) {
+	a.writeRecordVersion(w, r, true)
+}
+
+// PATCH /{collection}/{id}?effective_from=<RFC3339>&effective_to=<RFC3339>&expected_version=<n>
+// appends a new version to an existing record.
+// Responds with the version that was written.
+func (a *V2API) PatchRecordVersion(w http.ResponseWriter, r *http.Request) {
+	a.writeRecordVersion(w, r, false)
+}
+
+func (a *V2API) writeRecordVersion(w http.ResponseWriter, r *http.Request, createIfMissing bool) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	opts, err := writeOptionsFromRequest(r)
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	if r.Header.Get("If-Match") == "*" {
+		createIfMissing = false
+	}
+
+	// Values may be any JSON value; numbers keep their exact representation.
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	var body map[string]interface{}
+	if err := decoder.Decode(&body); err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	recordVersion, err := records.UpdateRecordVersion(ctx, int(idNumber), body, opts)
+	if errors.Is(err, service.ErrRecordDoesNotExist) && createIfMissing {
+		// the delete updates are skipped on create
+		recordVersion, err = records.CreateRecordVersion(ctx, int(idNumber), body, opts)
+	}
+	if err != nil {
+		writeVersionWriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(recordVersion.Version))
+	err = writeJSON(w, recordVersion, http.StatusOK)
+	logError(err)
+}
+
+// writeVersionWriteError maps the errors a versioned write can fail with to a response.
+func writeVersionWriteError(w http.ResponseWriter, r *http.Request, err error) {
+	statusCode, message, fields := versionWriteErrorResponse(err, r.Header.Get("If-Match") != "")
+
+	errInWriting := writeError(w, message, statusCode, fields...)
+	logError(err)
+	logError(errInWriting)
+}
+
+// versionWriteErrorResponse picks the status code, message and field errors
+// for an error from a versioned write. conditional is whether the write was
+// conditioned on If-Match.
+func versionWriteErrorResponse(err error, conditional bool) (int, string, []entity.FieldError) {
+	statusCode := http.StatusInternalServerError
+	message := ErrInternal.Error()
+	var fields []entity.FieldError
+	var validationErr *service.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		statusCode = http.StatusBadRequest
+		message = err.Error()
+		fields = validationErr.Fields
+	case errors.Is(err, service.ErrSchemaDoesNotExist):
+		statusCode = http.StatusBadRequest
+		message = "the record's schema does not exist"
+	case errors.Is(err, service.ErrRecordDoesNotExist) && conditional:
+		statusCode = http.StatusPreconditionFailed
+		message = "record does not exist"
+	case errors.Is(err, service.ErrRecordDoesNotExist):
+		statusCode = http.StatusBadRequest
+		message = "record does not exist"
+	case errors.Is(err, service.ErrRecordVersionDoesNotExist):
+		statusCode = http.StatusBadRequest
+		message = "record/version does not exist"
+	case errors.Is(err, service.ErrVersionConflict) && conditional:
+		statusCode = http.StatusPreconditionFailed
+		message = err.Error()
+	case errors.Is(err, service.ErrVersionConflict):
+		statusCode = http.StatusConflict
+		message = err.Error()
+	case errors.Is(err, service.ErrRecordAlreadyExists):
+		statusCode = http.StatusConflict
+		message = "record was created concurrently; retry the request"
+	case errors.Is(err, service.ErrRecordNotDeleted):
+		statusCode = http.StatusConflict
+		message = err.Error()
+	case errors.Is(err, service.ErrEffectiveRangeInvalid), errors.Is(err, service.ErrBackdatedUpdate):
+		statusCode = http.StatusBadRequest
+		message = err.Error()
+	}
+	return statusCode, message, fields
+}
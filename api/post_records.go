@@ -35,30 +35,33 @@ func (a *API) PostRecords(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// first retrieve the record
-	record, err := a.records.GetRecord(
+	_, err = a.records.GetRecord(
 		ctx,
 		int(idNumber),
 	)
 
+	var recordVersion entity.RecordVersion
 	if !errors.Is(err, service.ErrRecordDoesNotExist) { // record exists
-		record, err = a.records.UpdateRecord(ctx, int(idNumber), body)
+		recordVersion, err = a.records.UpdateRecordVersion(ctx, int(idNumber), service.TypedUpdates(body), auditOptionsFromRequest(r))
 	} else { // record does not exist
+		// the delete updates are kept in the change set but not set
+		recordVersion, err = a.records.CreateRecordVersion(ctx, int(idNumber), service.TypedUpdates(body), auditOptionsFromRequest(r))
+	}
 
-		// exclude the delete updates
-		recordMap := map[string]string{}
-		for key, value := range body {
-			if value != nil {
-				recordMap[key] = *value
-			}
-		}
-
-		record = entity.Record{
-			ID:   int(idNumber),
-			Data: recordMap,
-		}
-		err = a.records.CreateRecord(ctx, record)
+	var validationErr *service.ValidationError
+	if errors.As(err, &validationErr) {
+		errInWriting := writeError(w, err.Error(), http.StatusBadRequest, validationErr.Fields...)
+		logError(errInWriting)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
 	}
 
+	record, err := service.V1Record(recordVersion)
 	if err != nil {
 		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
 		logError(err)
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// POST /schemas/{name}
+// registers the JSON Schema in the body as the next version of {name}.
+func (a *V2API) PostSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	schemas, ok := schemaService(w, a.records)
+	if !ok {
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	schema, err := schemas.RegisterSchema(ctx, mux.Vars(r)["name"], body)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		switch {
+		case errors.Is(err, service.ErrSchemaNameInvalid), errors.Is(err, service.ErrSchemaInvalid):
+			statusCode = http.StatusBadRequest
+			message = err.Error()
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, schema, http.StatusOK)
+	logError(err)
+}
+
+// schemaService returns the schema support of records, writing a 501 if it
+// has none.
+func schemaService(w http.ResponseWriter, records service.VersionedRecordService) (service.SchemaService, bool) {
+	schemas, ok := records.(service.SchemaService)
+	if !ok {
+		err := writeError(w, "schemas are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+	}
+	return schemas, ok
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// POST /transactions?effective_from=<RFC3339>&effective_to=<RFC3339>
+// applies a list of writes across records atomically. The body is
+// {"operations": [{"op": "create"|"update"|"delete", "collection": "...",
+// "id": n, "data": {...}, "expected_version": n}, ...]}; collection defaults
+// to records. Every version written shares one created_at_ms and transaction_id.
+// Responds with the transaction and the versions it wrote.
+func (a *V2API) PostTransaction(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	transactions, ok := a.records.(service.TransactionService)
+	if !ok {
+		err := writeError(w, "transactions are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+
+	var body struct {
+		Operations []entity.Operation `json:"operations"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	opts, err := writeOptionsFromRequest(r)
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	opts.ExpectedVersion = nil
+
+	transaction, err := transactions.ApplyTransaction(ctx, body.Operations, opts)
+	if err != nil {
+		cause := err
+		var operationErr *service.OperationError
+		if errors.As(err, &operationErr) {
+			cause = operationErr.Err
+		}
+
+		statusCode, message, fields := versionWriteErrorResponse(cause, false)
+		switch {
+		case errors.Is(cause, service.ErrTransactionEmpty),
+			errors.Is(cause, service.ErrOperationInvalid):
+			statusCode = http.StatusBadRequest
+			message = cause.Error()
+		case errors.Is(cause, service.ErrCollectionDoesNotExist):
+			statusCode = http.StatusBadRequest
+			message = "collection does not exist"
+		case errors.Is(cause, service.ErrRecordAlreadyExists):
+			message = "record already exists"
+		}
+		if operationErr != nil && statusCode != http.StatusInternalServerError {
+			message = fmt.Sprintf("operation %d: %s", operationErr.Index, message)
+		}
+
+		errInWriting := writeError(w, message, statusCode, fields...)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, transaction, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// POST /webhooks
+// subscribes {"url": "...", "collection": "...", "secret": "..."} to every
+// new version, in one collection or all of them when none is given. The
+// response includes the secret the requests are signed with, generated when
+// none is given; it is not shown again.
+func (a *V2API) PostWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	webhooks, ok := webhookService(w, a.records)
+	if !ok {
+		return
+	}
+
+	var body entity.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	webhook, err := webhooks.CreateWebhook(ctx, entity.Webhook{
+		URL:        body.URL,
+		Collection: body.Collection,
+		Secret:     body.Secret,
+	})
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		switch {
+		case errors.Is(err, service.ErrWebhookURLInvalid), errors.Is(err, service.ErrWebhookHostNotAllowed):
+			statusCode = http.StatusBadRequest
+			message = err.Error()
+		case errors.Is(err, service.ErrCollectionDoesNotExist):
+			statusCode = http.StatusBadRequest
+			message = "collection does not exist"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, webhook, http.StatusOK)
+	logError(err)
+}
+
+// webhookService returns the webhook support of records, writing a 501 if it
+// has none.
+func webhookService(w http.ResponseWriter, records service.VersionedRecordService) (service.WebhookService, bool) {
+	webhooks, ok := records.(service.WebhookService)
+	if !ok {
+		err := writeError(w, "webhooks are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+	}
+	return webhooks, ok
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// PUT /collections/{name}
+// creates a collection, or changes the schema of an existing one. The
+// optional body is {"schema_name": "...", "schema_version": n}; leaving out
+// the version follows the latest one. The schema applies to later writes of
+// records that have no schema of their own.
+func (a *V2API) PutCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	collections, ok := a.records.(service.CollectionService)
+	if !ok {
+		err := writeError(w, "collections are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+
+	var body entity.Collection
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	body.Name = mux.Vars(r)["name"]
+
+	collection, err := collections.PutCollection(ctx, body)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		switch {
+		case errors.Is(err, service.ErrCollectionNameInvalid), errors.Is(err, service.ErrCollectionNameReserved):
+			statusCode = http.StatusBadRequest
+			message = err.Error()
+		case errors.Is(err, service.ErrSchemaDoesNotExist):
+			statusCode = http.StatusBadRequest
+			message = "schema does not exist"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, collection, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// PUT /{collection}/{id}/links/{type}/{target_collection}/{target_id}
+// links the record to another record, e.g. /policies/1/links/driver/drivers/7.
+// The target has to exist. Responds with the link version that was written.
+func (a *V2API) PutRecordLink(w http.ResponseWriter, r *http.Request) {
+	a.writeRecordLink(w, r, false)
+}
+
+// DELETE /{collection}/{id}/links/{type}/{target_collection}/{target_id}
+// removes a link; it stays visible when reading at earlier times.
+func (a *V2API) DeleteRecordLink(w http.ResponseWriter, r *http.Request) {
+	a.writeRecordLink(w, r, true)
+}
+
+func (a *V2API) writeRecordLink(w http.ResponseWriter, r *http.Request, remove bool) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	links, ok := linkService(w, records)
+	if !ok {
+		return
+	}
+
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	targetIDNumber, err := strconv.ParseInt(vars["target_id"], 10, 32)
+	if err != nil || targetIDNumber <= 0 {
+		err := writeError(w, "invalid target_id; target_id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	opts, err := writeOptionsFromRequest(r)
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	link := entity.Link{Type: vars["type"], Collection: vars["target_collection"], ID: int(targetIDNumber)}
+	if remove {
+		link, err = links.UnlinkRecord(ctx, int(idNumber), link, opts)
+	} else {
+		link, err = links.LinkRecord(ctx, int(idNumber), link, opts)
+	}
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		switch {
+		case errors.Is(err, service.ErrRecordDoesNotExist):
+			statusCode = http.StatusBadRequest
+			message = "record does not exist"
+		case errors.Is(err, service.ErrLinkTargetDoesNotExist),
+			errors.Is(err, service.ErrLinkDoesNotExist),
+			errors.Is(err, service.ErrLinkTypeInvalid):
+			statusCode = http.StatusBadRequest
+			message = err.Error()
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, link, http.StatusOK)
+	logError(err)
+}
+
+// linkService returns the link support of records, writing a 501 if it has none.
+func linkService(w http.ResponseWriter, records service.VersionedRecordService) (service.LinkService, bool) {
+	links, ok := records.(service.LinkService)
+	if !ok {
+		err := writeError(w, "links are not supported by this store", http.StatusNotImplemented)
+		logError(err)
+	}
+	return links, ok
+}
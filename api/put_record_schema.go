@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GET /{collection}/{id}/schema
+// returns the schema the record's writes are validated against.
+func (a *V2API) GetRecordSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	schemas, ok := schemaService(w, records)
+	if !ok {
+		return
+	}
+
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	assignment, err := schemas.GetRecordSchema(ctx, int(idNumber))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		if errors.Is(err, service.ErrSchemaDoesNotExist) {
+			statusCode = http.StatusBadRequest
+			message = "record has no schema"
+		}
+
+		errInWriting := writeError(w, message, statusCode)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, assignment, http.StatusOK)
+	logError(err)
+}
+
+// PUT /{collection}/{id}/schema
+// validates the record's writes against a schema from now on. The body is
+// {"name": "...", "version": n}; leaving out the version follows the latest
+// one. The record's current data must already satisfy the schema.
+func (a *V2API) PutRecordSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	records, ok := a.collection(w, r)
+	if !ok {
+		return
+	}
+	schemas, ok := schemaService(w, records)
+	if !ok {
+		return
+	}
+
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var body entity.RecordSchema
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		err := writeError(w, "invalid input; expected {\"name\": ..., \"version\": ...}", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	body.ID = int(idNumber)
+
+	assignment, err := schemas.AssignSchema(ctx, body)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		message := ErrInternal.Error()
+		var fields []entity.FieldError
+		var validationErr *service.ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			statusCode = http.StatusBadRequest
+			message = "the record's current data does not match the schema"
+			fields = validationErr.Fields
+		case errors.Is(err, service.ErrSchemaDoesNotExist):
+			statusCode = http.StatusBadRequest
+			message = "schema does not exist"
+		}
+
+		errInWriting := writeError(w, message, statusCode, fields...)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, assignment, http.StatusOK)
+	logError(err)
+}
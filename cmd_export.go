@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// runExport implements `timetravel export [flags]`, which streams the full
+// history of a collection as NDJSON or CSV to a file or stdout.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := flags.String("db", defaultDBPath, "path of the SQLite database")
+	collection := flags.String("collection", service.DefaultCollection, "collection to export")
+	format := flags.String("format", service.ExportFormatNDJSON, "ndjson or csv")
+	fromID := flags.Int("from-id", 0, "lowest record id to export")
+	toID := flags.Int("to-id", 0, "highest record id to export")
+	from := flags.String("from", "", "export versions created at or after this RFC3339 time")
+	to := flags.String("to", "", "export versions created before this RFC3339 time")
+	output := flags.String("o", "-", "file to write to, or - for stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: timetravel export [flags]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	opts := service.ExportOptions{Format: *format, FromID: *fromID, ToID: *toID}
+	var err error
+	if opts.FromMS, err = parseTimeFlag(*from); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -from: %v\n", err)
+		return 2
+	}
+	if opts.ToMS, err = parseTimeFlag(*to); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -to: %v\n", err)
+		return 2
+	}
+
+	recordService, err := service.NewDBRecordService(*dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() { logError(recordService.Close()) }()
+
+	ctx := context.Background()
+	records, err := recordService.Collection(ctx, *collection)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "collection %q: %v\n", *collection, err)
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer func() { logError(file.Close()) }()
+		out = file
+	}
+	buffered := bufio.NewWriter(out)
+
+	if err := records.(service.ExportService).ExportRecords(ctx, buffered, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := buffered.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// parseTimeFlag parses an optional RFC3339 flag into unix milliseconds.
+func parseTimeFlag(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, err
+	}
+	ms := t.UTC().UnixMilli()
+	return &ms, nil
+}
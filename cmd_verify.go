@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// runVerify implements `timetravel verify [flags]`, which walks the hash
+// chains through every version in the database, or through one record with
+// -id, and prints the result. It exits 1 if a link is broken.
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	dbPath := flags.String("db", defaultDBPath, "path of the SQLite database")
+	collection := flags.String("collection", service.DefaultCollection, "collection of the record given by -id")
+	id := flags.Int("id", 0, "verify only this record")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: timetravel verify [flags]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	recordService, err := service.NewDBRecordService(*dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() { logError(recordService.Close()) }()
+
+	ctx := context.Background()
+	var verification entity.Verification
+	if *id == 0 {
+		verification, err = recordService.VerifyAll(ctx)
+	} else {
+		var records service.VersionedRecordService
+		records, err = recordService.Collection(ctx, *collection)
+		if err == nil {
+			verification, err = records.(service.VerifyService).VerifyRecord(ctx, *id)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(verification); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !verification.Valid {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,9 @@
+package entity
+
+// Change is a version as it appears in the change feed. Seq orders every
+// version, across all collections, in the order it was committed.
+type Change struct {
+	Seq        int64  `json:"seq"`
+	Collection string `json:"collection"`
+	RecordVersion
+}
@@ -0,0 +1,29 @@
+package entity
+
+// Checkpoint is a signed Merkle root over the first TreeSize versions in seq
+// order. Each leaf is SHA-256(0x00 || record_hash) and each node is
+// SHA-256(0x01 || left || right), as in RFC 6962. Signature is the Ed25519
+// signature, by PublicKey, of the CheckpointMessage; hashes are hex and keys
+// and signatures are base64.
+type Checkpoint struct {
+	ID          int    `json:"id"`
+	TreeSize    int64  `json:"tree_size"`
+	RootHash    string `json:"root_hash"`
+	CreatedAtMS int64  `json:"created_at_ms"`
+	PublicKey   string `json:"public_key"`
+	Signature   string `json:"signature"`
+}
+
+// InclusionProof shows that a version is a leaf of a checkpoint's tree.
+// Hashing the leaf up the audit path, as in RFC 6962, gives the root hash.
+type InclusionProof struct {
+	Collection string     `json:"collection"`
+	ID         int        `json:"id"`
+	Version    int        `json:"version"`
+	Seq        int64      `json:"seq"`
+	RecordHash string     `json:"record_hash"`
+	LeafIndex  int64      `json:"leaf_index"`
+	LeafHash   string     `json:"leaf_hash"`
+	AuditPath  []string   `json:"audit_path"`
+	Checkpoint Checkpoint `json:"checkpoint"`
+}
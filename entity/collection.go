@@ -0,0 +1,11 @@
+package entity
+
+// Collection is a named set of records with its own id space. When it has a
+// schema, every record in it is validated against that schema unless the
+// record has one of its own.
+type Collection struct {
+	Name          string `json:"name"`
+	SchemaName    string `json:"schema_name,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	CreatedAtMS   int64  `json:"created_at_ms"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+// FieldHistory lists every value a single key of a record has had.
+type FieldHistory struct {
+	ID      int           `json:"id"`
+	Key     string        `json:"key"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// FieldChange is a version in which the key was set, changed or removed.
+type FieldChange struct {
+	Version         int         `json:"version"`
+	CreatedAtMS     int64       `json:"created_at_ms"`
+	EffectiveFromMS int64       `json:"effective_from_ms"`
+	Value           interface{} `json:"value"`
+	Removed         bool        `json:"removed,omitempty"`
+	Actor           string      `json:"actor,omitempty"`
+	Reason          string      `json:"reason,omitempty"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+// ImportReport summarises an import. Lines that could not be imported are
+// listed in Errors; the rest were imported, or would have been on a dry run.
+type ImportReport struct {
+	DryRun   bool          `json:"dry_run,omitempty"`
+	Lines    int           `json:"lines"`
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// ImportError explains why a line was not imported.
+type ImportError struct {
+	Line   int          `json:"line"`
+	ID     int          `json:"id,omitempty"`
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
@@ -0,0 +1,29 @@
+package entity
+
+// Link is a typed edge from one record to another, e.g. from a policy to
+// one of its drivers. Every change to a link is a new version.
+type Link struct {
+	Type        string `json:"type"`
+	Collection  string `json:"collection"`
+	ID          int    `json:"id"`
+	Version     int    `json:"version,omitempty"`
+	CreatedAtMS int64  `json:"created_at_ms,omitempty"`
+	Removed     bool   `json:"removed,omitempty"`
+	Actor       string `json:"actor,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// ExpandedRecord is a record together with the records it links to, all
+// read as of the same time.
+type ExpandedRecord struct {
+	AtMS   int64          `json:"at_ms"`
+	Record RecordVersion  `json:"record"`
+	Links  []ExpandedLink `json:"links"`
+}
+
+// ExpandedLink is a link and the record it pointed to. Record is nil when the
+// target did not exist at that time.
+type ExpandedLink struct {
+	Link
+	Record *RecordVersion `json:"record"`
+}
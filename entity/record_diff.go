@@ -0,0 +1,16 @@
+package entity
+
+// RecordDiff describes how a record's data changed between two versions.
+type RecordDiff struct {
+	ID          int                    `json:"id"`
+	FromVersion int                    `json:"from_version"`
+	ToVersion   int                    `json:"to_version"`
+	Added       map[string]interface{} `json:"added"`
+	Removed     map[string]interface{} `json:"removed"`
+	Changed     map[string]ValueChange `json:"changed"`
+}
+
+type ValueChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
@@ -0,0 +1,10 @@
+package entity
+
+// RecordList is a page of records, each at the version that was current
+// at the time the list was evaluated.
+type RecordList struct {
+	Records []RecordVersion `json:"records"`
+
+	// NextCursor is the after_id of the next page, if there is one.
+	NextCursor *int `json:"next_cursor,omitempty"`
+}
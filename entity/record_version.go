@@ -1,8 +1,16 @@
 package entity
 
 type RecordVersion struct {
-	ID          int               `json:"id"`
-	Version     int               `json:"version"`
-	CreatedAtMS int64             `json:"created_at_ms"`
-	Data        map[string]string `json:"data"`
+	ID                int                    `json:"id"`
+	Version           int                    `json:"version"`
+	CreatedAtMS       int64                  `json:"created_at_ms"`
+	EffectiveFromMS   int64                  `json:"effective_from_ms"`
+	EffectiveToMS     *int64                 `json:"effective_to_ms,omitempty"`
+	Data              map[string]interface{} `json:"data"`
+	Changes           map[string]interface{} `json:"changes,omitempty"`
+	RevertedToVersion *int                   `json:"reverted_to_version,omitempty"`
+	Deleted           bool                   `json:"deleted,omitempty"`
+	Actor             string                 `json:"actor,omitempty"`
+	Reason            string                 `json:"reason,omitempty"`
+	TransactionID     int                    `json:"transaction_id,omitempty"`
 }
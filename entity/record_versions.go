@@ -3,10 +3,21 @@ package entity
 type RecordVersions struct {
 	ID       int                 `json:"id"`
 	Versions []RecordVersionInfo `json:"versions"`
+
+	// NextCursor is the after_version of the next page, if there is one.
+	NextCursor *int `json:"next_cursor,omitempty"`
 }
 
 type RecordVersionInfo struct {
-	Version     int               `json:"version"`
-	CreatedAtMS int64             `json:"created_at_ms"`
-	Data        map[string]string `json:"data"`
+	Version           int                    `json:"version"`
+	CreatedAtMS       int64                  `json:"created_at_ms"`
+	EffectiveFromMS   int64                  `json:"effective_from_ms"`
+	EffectiveToMS     *int64                 `json:"effective_to_ms,omitempty"`
+	Data              map[string]interface{} `json:"data"` // null when the listing omitted data
+	Changes           map[string]interface{} `json:"changes,omitempty"`
+	RevertedToVersion *int                   `json:"reverted_to_version,omitempty"`
+	Deleted           bool                   `json:"deleted,omitempty"`
+	Actor             string                 `json:"actor,omitempty"`
+	Reason            string                 `json:"reason,omitempty"`
+	TransactionID     int                    `json:"transaction_id,omitempty"`
 }
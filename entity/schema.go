@@ -0,0 +1,27 @@
+package entity
+
+import "encoding/json"
+
+// Schema is one registered version of a JSON Schema that records can be
+// validated against.
+type Schema struct {
+	Name        string          `json:"name"`
+	Version     int             `json:"version"`
+	CreatedAtMS int64           `json:"created_at_ms"`
+	Schema      json.RawMessage `json:"schema"`
+}
+
+// RecordSchema is the schema a record's writes are validated against. A
+// version of 0 follows the latest registered version of the schema.
+type RecordSchema struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version,omitempty"`
+}
+
+// FieldError explains why a single field failed validation. Field is a JSON
+// pointer into the record's data; it is empty for errors about the data as a whole.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,37 @@
+package entity
+
+// The kinds of operation a transaction can contain.
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+	OperationPut    = "put"
+)
+
+// Operation is one write of a transaction. Updates set the keys in Data and
+// remove the ones that are null, like PATCH. Puts create the record, or
+// replace all of its data if it exists.
+type Operation struct {
+	Op              string                 `json:"op"`
+	Collection      string                 `json:"collection,omitempty"`
+	ID              int                    `json:"id"`
+	Data            map[string]interface{} `json:"data,omitempty"`
+	ExpectedVersion *int                   `json:"expected_version,omitempty"`
+}
+
+// Transaction is a group of versions that were written together. They all
+// share its created_at_ms and carry its id.
+type Transaction struct {
+	ID          int                  `json:"id"`
+	CreatedAtMS int64                `json:"created_at_ms"`
+	Actor       string               `json:"actor,omitempty"`
+	Reason      string               `json:"reason,omitempty"`
+	Versions    []TransactionVersion `json:"versions"`
+}
+
+// TransactionVersion is a version written by a transaction, along with the
+// collection of its record.
+type TransactionVersion struct {
+	Collection string `json:"collection"`
+	RecordVersion
+}
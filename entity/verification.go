@@ -0,0 +1,29 @@
+package entity
+
+// The hash chains a BrokenLink can be in. Every version's hash covers its
+// content and the hash of the record's previous version; its chain hash
+// covers that hash and the chain hash of the version written just before it
+// in any record.
+const (
+	ChainRecord = "record"
+	ChainGlobal = "global"
+)
+
+// Verification is the result of walking the hash chains.
+type Verification struct {
+	Valid   bool        `json:"valid"`
+	Checked int         `json:"checked"`
+	Broken  *BrokenLink `json:"broken,omitempty"`
+}
+
+// BrokenLink is the first version whose stored hash does not follow from its
+// content and the version before it: the version, or the one before it, was
+// changed or removed after it was written.
+type BrokenLink struct {
+	Collection string `json:"collection"`
+	ID         int    `json:"id"`
+	Version    int    `json:"version"`
+	Seq        int64  `json:"seq"`
+	Chain      string `json:"chain"`
+	Reason     string `json:"reason"`
+}
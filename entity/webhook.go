@@ -0,0 +1,32 @@
+package entity
+
+// Webhook is a subscriber that receives every new version, in one collection
+// or in all of them, as an HTTP POST of its Change. Each request is signed
+// with Secret, which is only returned when the webhook is created.
+type Webhook struct {
+	ID          int    `json:"id"`
+	URL         string `json:"url"`
+	Collection  string `json:"collection,omitempty"`
+	Secret      string `json:"secret,omitempty"`
+	CreatedAtMS int64  `json:"created_at_ms"`
+}
+
+// The states of a WebhookDelivery.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	// WebhookDeliveryDead is a delivery that ran out of attempts.
+	WebhookDeliveryDead = "dead"
+)
+
+// WebhookDelivery is the delivery of one change to one webhook.
+type WebhookDelivery struct {
+	ID              int    `json:"id"`
+	WebhookID       int    `json:"webhook_id"`
+	Seq             int64  `json:"seq"`
+	Status          string `json:"status"`
+	Attempts        int    `json:"attempts"`
+	LastError       string `json:"last_error,omitempty"`
+	LastAttemptAtMS int64  `json:"last_attempt_at_ms,omitempty"`
+	CreatedAtMS     int64  `json:"created_at_ms"`
+}
@@ -5,3 +5,7 @@ go 1.17
 require github.com/gorilla/mux v1.8.0
 
 require github.com/mattn/go-sqlite3 v1.14.33
+
+require github.com/santhosh-tekuri/jsonschema/v5 v5.3.0
+
+require github.com/lib/pq v1.10.9
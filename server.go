@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -11,6 +16,77 @@ import (
 	"github.com/rainbowmga/timetravel/service"
 )
 
+// defaultDBPath is where the server and the CLI subcommands keep their database.
+const defaultDBPath = "timetravel.db"
+
+// Record storage backends, selected with TIMETRAVEL_BACKEND.
+const (
+	backendSQLite   = "sqlite"
+	backendMemory   = "memory"
+	backendPostgres = "postgres"
+)
+
+// config is the server's configuration, read from the environment.
+type config struct {
+	// Backend is where records are kept: backendSQLite (the default), in
+	// defaultDBPath; backendPostgres, in the database at PostgresDSN; or
+	// backendMemory, which loses them on exit. Only backendSQLite has the
+	// optional services such as collections, webhooks or checkpoints.
+	Backend string
+	// PostgresDSN is the connection string for backendPostgres, from
+	// TIMETRAVEL_POSTGRES_DSN.
+	PostgresDSN string
+	// CheckpointKey signs checkpoints. TIMETRAVEL_CHECKPOINT_KEY holds a
+	// base64 Ed25519 seed or private key; without one no checkpoints are made.
+	CheckpointKey ed25519.PrivateKey
+	// CheckpointInterval is how often a checkpoint is made while records are
+	// being written. TIMETRAVEL_CHECKPOINT_INTERVAL, default 1h.
+	CheckpointInterval time.Duration
+}
+
+func loadConfig() (config, error) {
+	cfg := config{Backend: backendSQLite, CheckpointInterval: time.Hour}
+
+	if value := os.Getenv("TIMETRAVEL_BACKEND"); value != "" {
+		switch value {
+		case backendSQLite, backendMemory, backendPostgres:
+			cfg.Backend = value
+		default:
+			return config{}, fmt.Errorf("TIMETRAVEL_BACKEND must be %q, %q or %q", backendSQLite, backendPostgres, backendMemory)
+		}
+	}
+
+	cfg.PostgresDSN = os.Getenv("TIMETRAVEL_POSTGRES_DSN")
+	if cfg.Backend == backendPostgres && cfg.PostgresDSN == "" {
+		return config{}, fmt.Errorf("TIMETRAVEL_POSTGRES_DSN is required by the %s backend", backendPostgres)
+	}
+
+	if value := os.Getenv("TIMETRAVEL_CHECKPOINT_KEY"); value != "" {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return config{}, fmt.Errorf("TIMETRAVEL_CHECKPOINT_KEY: %w", err)
+		}
+		switch len(key) {
+		case ed25519.SeedSize:
+			cfg.CheckpointKey = ed25519.NewKeyFromSeed(key)
+		case ed25519.PrivateKeySize:
+			cfg.CheckpointKey = ed25519.PrivateKey(key)
+		default:
+			return config{}, fmt.Errorf("TIMETRAVEL_CHECKPOINT_KEY must be a %d byte seed or a %d byte private key", ed25519.SeedSize, ed25519.PrivateKeySize)
+		}
+	}
+
+	if value := os.Getenv("TIMETRAVEL_CHECKPOINT_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return config{}, fmt.Errorf("TIMETRAVEL_CHECKPOINT_INTERVAL must be a positive duration such as 15m")
+		}
+		cfg.CheckpointInterval = interval
+	}
+
+	return cfg, nil
+}
+
 // logError logs all non-nil errors
 func logError(err error) {
 	if err != nil {
@@ -19,13 +95,61 @@ func logError(err error) {
 }
 
 func main() {
-	router := mux.NewRouter()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q; usage: timetravel [export|import|verify]\n", os.Args[1])
+			os.Exit(2)
+		}
+	}
 
-	recordService, err := service.NewDBRecordService("timetravel.db")
+	cfg, err := loadConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func() { logError(recordService.Close()) }()
+
+	router := mux.NewRouter()
+
+	var recordService service.VersionedRecordService
+	switch cfg.Backend {
+	case backendMemory:
+		if cfg.CheckpointKey != nil {
+			log.Printf("the %s backend does not make checkpoints; ignoring TIMETRAVEL_CHECKPOINT_KEY", backendMemory)
+		}
+		recordService = service.NewMemoryRecordService()
+	case backendPostgres:
+		if cfg.CheckpointKey != nil {
+			log.Printf("the %s backend does not make checkpoints; ignoring TIMETRAVEL_CHECKPOINT_KEY", backendPostgres)
+		}
+		postgresRecordService, err := service.NewPostgresRecordService(cfg.PostgresDSN)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { logError(postgresRecordService.Close()) }()
+		recordService = postgresRecordService
+	default:
+		dbRecordService, err := service.NewDBRecordService(defaultDBPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { logError(dbRecordService.Close()) }()
+
+		// Delivers the webhook outbox in the background.
+		dispatcher := service.NewWebhookDispatcher(dbRecordService, service.WebhookDispatcherOptions{OnError: logError})
+		go dispatcher.Run(context.Background())
+
+		if cfg.CheckpointKey != nil {
+			dbRecordService.SetCheckpointKey(cfg.CheckpointKey)
+			go dbRecordService.RunCheckpoints(context.Background(), cfg.CheckpointInterval, logError)
+		}
+		recordService = dbRecordService
+	}
 
 	v1API := api.NewAPI(recordService)
 	v2API := api.NewV2API(recordService)
@@ -41,6 +165,7 @@ func main() {
 	v2API.CreateRoutes(v2Route)
 
 	address := "127.0.0.1:8000"
+	// The change feed and exports lift the WriteTimeout for themselves.
 	srv := &http.Server{
 		Handler:      router,
 		Addr:         address,
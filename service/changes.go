@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// DefaultChangesLimit is how many changes ListChanges returns when no limit is given.
+const DefaultChangesLimit = 100
+
+var ErrChangesOptionsInvalid = errors.New("after must be >= 0 and limit must be between 0 and 1000")
+
+// ChangesOptions selects a page of the change feed.
+type ChangesOptions struct {
+	// AfterSeq returns only changes after this sequence number; 0 starts at
+	// the beginning of the feed.
+	AfterSeq int64
+	// Collection narrows the feed to one collection; empty means every
+	// collection.
+	Collection string
+	// Limit caps the number of changes returned; 0 means DefaultChangesLimit.
+	Limit int
+}
+
+// ChangeFeedService reads every version ever written, in every collection,
+// in the order the writes were committed. A version's sequence number never
+// changes and no version is ever numbered below one already read, so a
+// consumer that remembers the last sequence number it processed can resume
+// without missing or repeating a change.
+type ChangeFeedService interface {
+	ListChanges(ctx context.Context, opts ChangesOptions) ([]entity.Change, error)
+}
+
+func (s *DBRecordService) ListChanges(ctx context.Context, opts ChangesOptions) ([]entity.Change, error) {
+	if opts.AfterSeq < 0 || opts.Limit < 0 || opts.Limit > 1000 {
+		return nil, ErrChangesOptionsInvalid
+	}
+	limit := opts.Limit
+	if limit == 0 {
+		limit = DefaultChangesLimit
+	}
+
+	query := `SELECT seq, collection, ` + recordVersionColumns + ` FROM record_versions WHERE seq > ?`
+	args := []interface{}{opts.AfterSeq}
+	if opts.Collection != "" {
+		if _, err := s.getCollection(ctx, s.readDB, opts.Collection); err != nil {
+			return nil, err
+		}
+		query += ` AND collection = ?`
+		args = append(args, opts.Collection)
+	}
+	query += ` ORDER BY seq ASC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.readDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	changes := []entity.Change{}
+	for rows.Next() {
+		var change entity.Change
+		change.RecordVersion, err = scanRecordVersion(changeScanner{row: rows, change: &change})
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// changeScanner scans a row selected with leading seq and collection columns
+// followed by recordVersionColumns.
+type changeScanner struct {
+	row    rowScanner
+	change *entity.Change
+}
+
+func (c changeScanner) Scan(dest ...interface{}) error {
+	return c.row.Scan(append([]interface{}{&c.change.Seq, &c.change.Collection}, dest...)...)
+}
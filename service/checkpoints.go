@@ -0,0 +1,482 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+var ErrCheckpointKeyMissing = errors.New("checkpoints need a signing key")
+var ErrCheckpointDoesNotExist = errors.New("checkpoint does not exist")
+var ErrVersionNotCheckpointed = errors.New("version was written after the checkpoint")
+var ErrSeqNotContiguous = errors.New("versions are missing from the change feed")
+
+// CheckpointService publishes signed Merkle roots over every version in the
+// store and proves that a version is included in one.
+type CheckpointService interface {
+	// CreateCheckpoint signs the root of the tree over every version written so far.
+	CreateCheckpoint(ctx context.Context) (entity.Checkpoint, error)
+	// ListCheckpoints returns every checkpoint, newest first.
+	ListCheckpoints(ctx context.Context) ([]entity.Checkpoint, error)
+	// ProveVersion returns the audit path of a version in a checkpoint, or in
+	// the latest checkpoint when checkpointID is 0.
+	ProveVersion(ctx context.Context, id int, version int, checkpointID int) (entity.InclusionProof, error)
+}
+
+// CheckpointMessage is the message a checkpoint's signature covers.
+func CheckpointMessage(checkpoint entity.Checkpoint) []byte {
+	return []byte(fmt.Sprintf("timetravel checkpoint v1\n%d\n%s\n%d\n", checkpoint.TreeSize, checkpoint.RootHash, checkpoint.CreatedAtMS))
+}
+
+// VerifyInclusionProof reports whether a proof's leaf hashes up its audit
+// path to the root of its checkpoint, and whether that checkpoint is signed
+// by its public key.
+func VerifyInclusionProof(proof entity.InclusionProof) bool {
+	recordHash, err := hex.DecodeString(proof.RecordHash)
+	if err != nil {
+		return false
+	}
+	leaf := merkleLeafHash(recordHash)
+	if hex.EncodeToString(leaf) != proof.LeafHash {
+		return false
+	}
+	path := make([][]byte, len(proof.AuditPath))
+	for i, sibling := range proof.AuditPath {
+		if path[i], err = hex.DecodeString(sibling); err != nil {
+			return false
+		}
+	}
+	root, err := hex.DecodeString(proof.Checkpoint.RootHash)
+	if err != nil {
+		return false
+	}
+	return merkleVerifyInclusion(proof.LeafIndex, proof.Checkpoint.TreeSize, leaf, path, root) &&
+		VerifyCheckpoint(proof.Checkpoint)
+}
+
+// VerifyCheckpoint reports whether a checkpoint is signed by its public key.
+func VerifyCheckpoint(checkpoint entity.Checkpoint) bool {
+	publicKey, err := base64.StdEncoding.DecodeString(checkpoint.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(publicKey), CheckpointMessage(checkpoint), signature)
+}
+
+// SetCheckpointKey sets the key checkpoints are signed with. Call it before
+// using the service; collections reached afterwards share the key.
+func (s *DBRecordService) SetCheckpointKey(key ed25519.PrivateKey) {
+	s.checkpointKey = key
+}
+
+func (s *DBRecordService) CreateCheckpoint(ctx context.Context) (entity.Checkpoint, error) {
+	if s.checkpointKey == nil {
+		return entity.Checkpoint{}, ErrCheckpointKeyMissing
+	}
+
+	// Hash the tree from a read snapshot so writes carry on meanwhile. The
+	// versions it covers never change, so the checkpoint can be stored after.
+	tx, err := s.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Checkpoint{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Leaf n-1 is the version with seq n, so a tree over a store missing a
+	// version would not match its size.
+	var treeSize, lastSeq int64
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(MAX(seq), 0) FROM record_versions`).Scan(&treeSize, &lastSeq); err != nil {
+		return entity.Checkpoint{}, err
+	}
+	if treeSize != lastSeq {
+		return entity.Checkpoint{}, ErrSeqNotContiguous
+	}
+	tree := newMerkleTree(tx)
+	if err := tree.extend(ctx, treeSize); err != nil {
+		return entity.Checkpoint{}, err
+	}
+	rootHash, err := tree.rangeHash(ctx, 0, treeSize)
+	if err != nil {
+		return entity.Checkpoint{}, err
+	}
+	if err := tx.Rollback(); err != nil {
+		return entity.Checkpoint{}, err
+	}
+
+	checkpoint := entity.Checkpoint{
+		TreeSize:    treeSize,
+		RootHash:    hex.EncodeToString(rootHash),
+		CreatedAtMS: time.Now().UTC().UnixMilli(),
+		PublicKey:   base64.StdEncoding.EncodeToString(s.checkpointKey.Public().(ed25519.PublicKey)),
+	}
+	checkpoint.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.checkpointKey, CheckpointMessage(checkpoint)))
+
+	write, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Checkpoint{}, err
+	}
+	defer func() { _ = write.Rollback() }()
+
+	if err := tree.store(ctx, write); err != nil {
+		return entity.Checkpoint{}, err
+	}
+	result, err := write.ExecContext(
+		ctx,
+		`INSERT INTO checkpoints (tree_size, root_hash, created_at_ms, public_key, signature) VALUES (?, ?, ?, ?, ?)`,
+		checkpoint.TreeSize,
+		checkpoint.RootHash,
+		checkpoint.CreatedAtMS,
+		checkpoint.PublicKey,
+		checkpoint.Signature,
+	)
+	if err != nil {
+		return entity.Checkpoint{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return entity.Checkpoint{}, err
+	}
+	if err := write.Commit(); err != nil {
+		return entity.Checkpoint{}, err
+	}
+	checkpoint.ID = int(id)
+	return checkpoint, nil
+}
+
+// RunCheckpoints creates a checkpoint every interval in which versions were
+// written, until ctx is done.
+func (s *DBRecordService) RunCheckpoints(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var grown bool
+		err := s.db.QueryRowContext(
+			ctx,
+			`SELECT COUNT(*) > COALESCE((SELECT tree_size FROM checkpoints ORDER BY id DESC LIMIT 1), 0) FROM record_versions`,
+		).Scan(&grown)
+		if err == nil && grown {
+			_, err = s.CreateCheckpoint(ctx)
+		}
+		if err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+func (s *DBRecordService) ListCheckpoints(ctx context.Context) ([]entity.Checkpoint, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+checkpointColumns+` FROM checkpoints ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	checkpoints := []entity.Checkpoint{}
+	for rows.Next() {
+		checkpoint, err := scanCheckpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints, rows.Err()
+}
+
+func (s *DBRecordService) ProveVersion(ctx context.Context, id int, version int, checkpointID int) (entity.InclusionProof, error) {
+	if id <= 0 {
+		return entity.InclusionProof{}, ErrRecordIDInvalid
+	}
+
+	// The proof only reads versions, checkpoints and subtree hashes that
+	// never change, from a read snapshot, so it doesn't hold up writes.
+	tx, err := s.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.InclusionProof{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	proof := entity.InclusionProof{Collection: s.collection, ID: id, Version: version}
+	var recordHash sql.NullString
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT seq, record_hash FROM record_versions WHERE collection = ? AND record_id = ? AND version = ?`,
+		s.collection,
+		id,
+		version,
+	).Scan(&proof.Seq, &recordHash)
+	if err == sql.ErrNoRows {
+		return entity.InclusionProof{}, ErrRecordVersionDoesNotExist
+	}
+	if err != nil {
+		return entity.InclusionProof{}, err
+	}
+	proof.RecordHash = recordHash.String
+
+	var row *sql.Row
+	if checkpointID == 0 {
+		row = tx.QueryRowContext(ctx, `SELECT `+checkpointColumns+` FROM checkpoints ORDER BY id DESC LIMIT 1`)
+	} else {
+		row = tx.QueryRowContext(ctx, `SELECT `+checkpointColumns+` FROM checkpoints WHERE id = ?`, checkpointID)
+	}
+	proof.Checkpoint, err = scanCheckpoint(row)
+	if err == sql.ErrNoRows {
+		return entity.InclusionProof{}, ErrCheckpointDoesNotExist
+	}
+	if err != nil {
+		return entity.InclusionProof{}, err
+	}
+	if proof.Seq > proof.Checkpoint.TreeSize {
+		return entity.InclusionProof{}, ErrVersionNotCheckpointed
+	}
+
+	leaf, err := hex.DecodeString(proof.RecordHash)
+	if err != nil {
+		return entity.InclusionProof{}, err
+	}
+	path, err := newMerkleTree(tx).auditPath(ctx, proof.Seq-1, 0, proof.Checkpoint.TreeSize)
+	if err != nil {
+		return entity.InclusionProof{}, err
+	}
+	proof.LeafIndex = proof.Seq - 1
+	proof.LeafHash = hex.EncodeToString(merkleLeafHash(leaf))
+	proof.AuditPath = []string{}
+	for _, sibling := range path {
+		proof.AuditPath = append(proof.AuditPath, hex.EncodeToString(sibling))
+	}
+	return proof, nil
+}
+
+// merkleNodeKey identifies the complete subtree over the leaves
+// [index<<level, (index+1)<<level).
+type merkleNodeKey struct {
+	level int64
+	index int64
+}
+
+// merkleTree is the tree over every version, read from the hashes of its
+// complete subtrees in merkle_nodes. Versions never change once written, so
+// neither do those hashes: checkpoints store the ones their versions
+// complete, and a root or audit path needs only O(log n) of them. Nodes
+// added since the tree was read are held in added until they are stored.
+type merkleTree struct {
+	db    queryer
+	added map[merkleNodeKey][]byte
+}
+
+func newMerkleTree(db queryer) *merkleTree {
+	return &merkleTree{db: db, added: map[merkleNodeKey][]byte{}}
+}
+
+// extend adds the leaves of the first treeSize versions that have no nodes
+// yet. The version with seq n is leaf n-1; ErrSeqNotContiguous is returned
+// if a seq is missing.
+func (t *merkleTree) extend(ctx context.Context, treeSize int64) error {
+	var stored int64
+	if err := t.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(idx) + 1, 0) FROM merkle_nodes WHERE level = 0`).Scan(&stored); err != nil {
+		return err
+	}
+	if stored >= treeSize {
+		return nil
+	}
+
+	rows, err := t.db.QueryContext(ctx, `SELECT seq, record_hash FROM record_versions WHERE seq > ? AND seq <= ? ORDER BY seq ASC`, stored, treeSize)
+	if err != nil {
+		return err
+	}
+	var leaves [][]byte
+	for rows.Next() {
+		var seq int64
+		var recordHash sql.NullString
+		if err := rows.Scan(&seq, &recordHash); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if seq != stored+int64(len(leaves))+1 {
+			_ = rows.Close()
+			return ErrSeqNotContiguous
+		}
+		data, err := hex.DecodeString(recordHash.String)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		leaves = append(leaves, merkleLeafHash(data))
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if stored+int64(len(leaves)) != treeSize {
+		return ErrSeqNotContiguous
+	}
+
+	for i, leaf := range leaves {
+		if err := t.appendLeaf(ctx, stored+int64(i), leaf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendLeaf adds the leaf at index and every subtree it completes.
+func (t *merkleTree) appendLeaf(ctx context.Context, index int64, leaf []byte) error {
+	key := merkleNodeKey{level: 0, index: index}
+	t.added[key] = leaf
+	hash := leaf
+	for key.index&1 == 1 {
+		left, err := t.node(ctx, merkleNodeKey{level: key.level, index: key.index - 1})
+		if err != nil {
+			return err
+		}
+		hash = merkleNodeHash(left, hash)
+		key = merkleNodeKey{level: key.level + 1, index: key.index >> 1}
+		t.added[key] = hash
+	}
+	return nil
+}
+
+// node returns the hash of a complete subtree. A subtree that was never
+// stored is over versions that were missing when the tree was extended.
+func (t *merkleTree) node(ctx context.Context, key merkleNodeKey) ([]byte, error) {
+	if hash, ok := t.added[key]; ok {
+		return hash, nil
+	}
+	var hash []byte
+	err := t.db.QueryRowContext(ctx, `SELECT hash FROM merkle_nodes WHERE level = ? AND idx = ?`, key.level, key.index).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return nil, ErrSeqNotContiguous
+	}
+	return hash, err
+}
+
+// rangeHash is the root hash of the subtree over the leaves
+// [start, start+size), split as in RFC 6962. Every subtree of a tree over
+// [0, n) that has a power-of-two size starts at a multiple of it, so it is
+// a stored node.
+func (t *merkleTree) rangeHash(ctx context.Context, start, size int64) ([]byte, error) {
+	switch {
+	case size == 0:
+		sum := sha256.Sum256(nil)
+		return sum[:], nil
+	case size&(size-1) == 0:
+		level := int64(bits.TrailingZeros64(uint64(size)))
+		return t.node(ctx, merkleNodeKey{level: level, index: start >> level})
+	}
+	k := merkleSplit(size)
+	left, err := t.rangeHash(ctx, start, k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.rangeHash(ctx, start+k, size-k)
+	if err != nil {
+		return nil, err
+	}
+	return merkleNodeHash(left, right), nil
+}
+
+// auditPath lists the sibling hashes from the leaf at index up to the root
+// of the subtree over [start, start+size).
+func (t *merkleTree) auditPath(ctx context.Context, index, start, size int64) ([][]byte, error) {
+	if size <= 1 {
+		return nil, nil
+	}
+	k := merkleSplit(size)
+	if index < start+k {
+		path, err := t.auditPath(ctx, index, start, k)
+		if err != nil {
+			return nil, err
+		}
+		sibling, err := t.rangeHash(ctx, start+k, size-k)
+		if err != nil {
+			return nil, err
+		}
+		return append(path, sibling), nil
+	}
+	path, err := t.auditPath(ctx, index, start+k, size-k)
+	if err != nil {
+		return nil, err
+	}
+	sibling, err := t.rangeHash(ctx, start, k)
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
+
+// store inserts the nodes added since the tree was read. A checkpoint made
+// meanwhile may have stored some of them already, with the same hashes.
+func (t *merkleTree) store(ctx context.Context, tx *sql.Tx) error {
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO merkle_nodes (level, idx, hash) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stmt.Close() }()
+
+	for key, hash := range t.added {
+		if _, err := stmt.ExecContext(ctx, key.level, key.index, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeCheckpointedMerkleNodes stores the nodes of checkpoints made before
+// merkle_nodes existed, so their versions can still be proven. If versions
+// are missing the nodes are left out, and proofs report ErrSeqNotContiguous.
+func storeCheckpointedMerkleNodes(db *sql.DB) error {
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var treeSize int64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(tree_size), 0) FROM checkpoints`).Scan(&treeSize); err != nil {
+		return err
+	}
+	tree := newMerkleTree(tx)
+	err = tree.extend(ctx, treeSize)
+	if err == ErrSeqNotContiguous {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := tree.store(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const checkpointColumns = "id, tree_size, root_hash, created_at_ms, public_key, signature"
+
+func scanCheckpoint(row rowScanner) (entity.Checkpoint, error) {
+	var checkpoint entity.Checkpoint
+	err := row.Scan(
+		&checkpoint.ID,
+		&checkpoint.TreeSize,
+		&checkpoint.RootHash,
+		&checkpoint.CreatedAtMS,
+		&checkpoint.PublicKey,
+		&checkpoint.Signature,
+	)
+	return checkpoint, err
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// DefaultCollection holds the records served by the v1 API.
+const DefaultCollection = "records"
+
+var ErrCollectionNameInvalid = errors.New("collection names must start with a lowercase letter and may only contain lowercase letters, digits, '_' and '-'")
+var ErrCollectionNameReserved = errors.New("collection name is reserved")
+var ErrCollectionDoesNotExist = errors.New("collection does not exist")
+
+// CollectionService gives access to named collections of records. Every
+// collection has its own id space and versions.
+type CollectionService interface {
+	// Collection returns the records of an existing collection.
+	Collection(ctx context.Context, name string) (VersionedRecordService, error)
+	ListCollections(ctx context.Context) ([]entity.Collection, error)
+	// PutCollection creates a collection, or updates the schema of an existing one.
+	PutCollection(ctx context.Context, collection entity.Collection) (entity.Collection, error)
+}
+
+var collectionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// reservedCollectionNames are the top-level v2 API paths, which cannot be a {collection}.
+var reservedCollectionNames = map[string]bool{
+	"changes":      true,
+	"checkpoints":  true,
+	"collections":  true,
+	"export":       true,
+	"import":       true,
+	"schemas":      true,
+	"snapshot":     true,
+	"transactions": true,
+	"webhooks":     true,
+}
+
+func (s *DBRecordService) Collection(ctx context.Context, name string) (VersionedRecordService, error) {
+	if _, err := s.getCollection(ctx, s.db, name); err != nil {
+		return nil, err
+	}
+	return s.inCollection(name), nil
+}
+
+func (s *DBRecordService) ListCollections(ctx context.Context) ([]entity.Collection, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, schema_name, schema_version, created_at_ms FROM collections ORDER BY name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	collections := []entity.Collection{}
+	for rows.Next() {
+		collection, err := scanCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	return collections, rows.Err()
+}
+
+func (s *DBRecordService) PutCollection(ctx context.Context, collection entity.Collection) (entity.Collection, error) {
+	if !collectionNamePattern.MatchString(collection.Name) {
+		return entity.Collection{}, ErrCollectionNameInvalid
+	}
+	if reservedCollectionNames[collection.Name] {
+		return entity.Collection{}, ErrCollectionNameReserved
+	}
+	if collection.SchemaName == "" {
+		collection.SchemaVersion = 0
+	}
+	if collection.SchemaVersion < 0 {
+		return entity.Collection{}, ErrSchemaDoesNotExist
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Collection{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if collection.SchemaName != "" {
+		if _, err := getSchema(ctx, tx, collection.SchemaName, collection.SchemaVersion); err != nil {
+			return entity.Collection{}, err
+		}
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO collections (name, schema_name, schema_version, created_at_ms) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (name) DO UPDATE SET schema_name = excluded.schema_name, schema_version = excluded.schema_version`,
+		collection.Name,
+		sql.NullString{String: collection.SchemaName, Valid: collection.SchemaName != ""},
+		collection.SchemaVersion,
+		time.Now().UTC().UnixMilli(),
+	); err != nil {
+		return entity.Collection{}, err
+	}
+
+	result, err := s.getCollection(ctx, tx, collection.Name)
+	if err != nil {
+		return entity.Collection{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.Collection{}, err
+	}
+	return result, nil
+}
+
+// inCollection returns a service for another collection sharing this one's database.
+func (s *DBRecordService) inCollection(name string) *DBRecordService {
+	scoped := *s
+	scoped.collection = name
+	return &scoped
+}
+
+func (s *DBRecordService) getCollection(ctx context.Context, db queryRower, name string) (entity.Collection, error) {
+	row := db.QueryRowContext(
+		ctx,
+		`SELECT name, schema_name, schema_version, created_at_ms FROM collections WHERE name = ?`,
+		name,
+	)
+	collection, err := scanCollection(row)
+	if err == sql.ErrNoRows {
+		return entity.Collection{}, ErrCollectionDoesNotExist
+	}
+	return collection, err
+}
+
+func scanCollection(row rowScanner) (entity.Collection, error) {
+	var collection entity.Collection
+	var schemaName sql.NullString
+	if err := row.Scan(&collection.Name, &schemaName, &collection.SchemaVersion, &collection.CreatedAtMS); err != nil {
+		return entity.Collection{}, err
+	}
+	collection.SchemaName = schemaName.String
+	return collection, nil
+}
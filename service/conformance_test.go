@@ -0,0 +1,566 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// testVersionedRecordService checks the behaviour every VersionedRecordService
+// backend has to share. newService returns an empty service.
+func testVersionedRecordService(t *testing.T, newService func(t *testing.T) VersionedRecordService) {
+	ctx := context.Background()
+	const base = int64(1700000000000)
+	at := func(ms int64) WriteOptions { return WriteOptions{createdAtMS: base + ms} }
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		svc := newService(t)
+
+		created, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"state": "CA", "limit": json.Number("5"), "gone": nil}, WriteOptions{Actor: "alice"})
+		if err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+		if created.Version != 1 || created.Actor != "alice" || created.EffectiveFromMS != created.CreatedAtMS {
+			t.Fatalf("unexpected version: %+v", created)
+		}
+		if _, ok := created.Data["gone"]; ok {
+			t.Fatalf("expected nil values to be dropped: %+v", created.Data)
+		}
+		history, err := svc.ListRecordVersions(ctx, 1, ListVersionsOptions{IncludeChanges: true})
+		if err != nil {
+			t.Fatalf("ListRecordVersions: %v", err)
+		}
+		if gone, ok := history.Versions[0].Changes["gone"]; !ok || gone != nil || history.Versions[0].Changes["state"] != "CA" {
+			t.Fatalf("expected the nulled key in the change set: %+v", history.Versions[0].Changes)
+		}
+		if _, err := svc.CreateRecordVersion(ctx, 1, nil, WriteOptions{}); err != ErrRecordAlreadyExists {
+			t.Fatalf("expected ErrRecordAlreadyExists, got %v", err)
+		}
+
+		updated, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"state": nil, "tags": []interface{}{"a"}}, WriteOptions{Reason: "portal"})
+		if err != nil {
+			t.Fatalf("UpdateRecordVersion: %v", err)
+		}
+		if updated.Version != 2 || updated.CreatedAtMS <= created.CreatedAtMS || updated.Reason != "portal" {
+			t.Fatalf("unexpected version: %+v", updated)
+		}
+
+		latest, err := svc.GetLatestRecordVersion(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetLatestRecordVersion: %v", err)
+		}
+		want := map[string]interface{}{"limit": json.Number("5"), "tags": []interface{}{"a"}}
+		if !reflect.DeepEqual(latest.Data, want) {
+			t.Fatalf("got %#v, want %#v", latest.Data, want)
+		}
+		if _, ok := latest.Changes["state"]; !ok || latest.Changes["state"] != nil {
+			t.Fatalf("unexpected changes: %+v", latest.Changes)
+		}
+
+		record, err := svc.GetRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecord: %v", err)
+		}
+		if !reflect.DeepEqual(record.Data, map[string]string{"limit": "5", "tags": `["a"]`}) {
+			t.Fatalf("unexpected record: %+v", record)
+		}
+
+		// Callers must not be able to change history through returned maps.
+		latest.Data["limit"] = json.Number("6")
+		if again, _ := svc.GetLatestRecordVersion(ctx, 1); again.Data["limit"] != json.Number("5") {
+			t.Fatalf("stored data was modified: %+v", again.Data)
+		}
+
+		deleted, err := svc.DeleteRecordVersion(ctx, 1, WriteOptions{})
+		if err != nil {
+			t.Fatalf("DeleteRecordVersion: %v", err)
+		}
+		if !deleted.Deleted || deleted.Version != 3 || len(deleted.Data) != 0 {
+			t.Fatalf("unexpected tombstone: %+v", deleted)
+		}
+		if _, err := svc.GetLatestRecordVersion(ctx, 1); err != ErrRecordDoesNotExist {
+			t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+		}
+		if _, err := svc.UpdateRecordVersion(ctx, 1, nil, WriteOptions{}); err != ErrRecordDoesNotExist {
+			t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+		}
+		if err := svc.DeleteRecord(ctx, 1); err != ErrRecordDoesNotExist {
+			t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+		}
+
+		restored, err := svc.RestoreRecord(ctx, 1, WriteOptions{})
+		if err != nil {
+			t.Fatalf("RestoreRecord: %v", err)
+		}
+		if restored.Version != 4 || restored.RevertedToVersion == nil || *restored.RevertedToVersion != 2 || !reflect.DeepEqual(restored.Data, want) {
+			t.Fatalf("unexpected restore: %+v", restored)
+		}
+		if _, err := svc.RestoreRecord(ctx, 1, WriteOptions{}); err != ErrRecordNotDeleted {
+			t.Fatalf("expected ErrRecordNotDeleted, got %v", err)
+		}
+
+		// Creating a deleted record again continues its history.
+		if _, err := svc.DeleteRecordVersion(ctx, 1, WriteOptions{}); err != nil {
+			t.Fatalf("DeleteRecordVersion: %v", err)
+		}
+		recreated, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"state": "NY"}, WriteOptions{})
+		if err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+		if recreated.Version != 6 {
+			t.Fatalf("unexpected version: %+v", recreated)
+		}
+	})
+
+	t.Run("Revert", func(t *testing.T) {
+		svc := newService(t)
+
+		if err := svc.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"limit": "1M", "state": "CA"}}); err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		limit, office := "5M", "SF"
+		if _, err := svc.UpdateRecord(ctx, 1, map[string]*string{"limit": &limit, "state": nil, "office": &office}); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+
+		reverted, err := svc.RevertRecord(ctx, 1, 1, WriteOptions{})
+		if err != nil {
+			t.Fatalf("RevertRecord: %v", err)
+		}
+		if reverted.Version != 3 || reverted.RevertedToVersion == nil || *reverted.RevertedToVersion != 1 {
+			t.Fatalf("unexpected version: %+v", reverted)
+		}
+		if !reflect.DeepEqual(reverted.Data, map[string]interface{}{"limit": "1M", "state": "CA"}) {
+			t.Fatalf("unexpected data: %+v", reverted.Data)
+		}
+		if !reflect.DeepEqual(reverted.Changes, map[string]interface{}{"limit": "1M", "state": "CA", "office": nil}) {
+			t.Fatalf("unexpected changes: %+v", reverted.Changes)
+		}
+
+		if _, err := svc.DeleteRecordVersion(ctx, 1, WriteOptions{}); err != nil {
+			t.Fatalf("DeleteRecordVersion: %v", err)
+		}
+		if _, err := svc.RestoreRecord(ctx, 1, WriteOptions{}); err != nil {
+			t.Fatalf("RestoreRecord: %v", err)
+		}
+		for _, toVersion := range []int{0, 4, 9} {
+			if _, err := svc.RevertRecord(ctx, 1, toVersion, WriteOptions{}); err != ErrRecordVersionDoesNotExist {
+				t.Fatalf("revert to %d: expected ErrRecordVersionDoesNotExist, got %v", toVersion, err)
+			}
+		}
+	})
+
+	t.Run("ExpectedVersion", func(t *testing.T) {
+		svc := newService(t)
+
+		stale := 0
+		if _, err := svc.CreateRecordVersion(ctx, 1, nil, WriteOptions{ExpectedVersion: &stale}); err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+		for name, write := range map[string]func() error{
+			"update": func() error {
+				_, err := svc.UpdateRecordVersion(ctx, 1, nil, WriteOptions{ExpectedVersion: &stale})
+				return err
+			},
+			"revert": func() error {
+				_, err := svc.RevertRecord(ctx, 1, 1, WriteOptions{ExpectedVersion: &stale})
+				return err
+			},
+			"delete": func() error {
+				_, err := svc.DeleteRecordVersion(ctx, 1, WriteOptions{ExpectedVersion: &stale})
+				return err
+			},
+		} {
+			if err := write(); err != ErrVersionConflict {
+				t.Fatalf("%s: expected ErrVersionConflict, got %v", name, err)
+			}
+		}
+
+		current := 1
+		if _, err := svc.DeleteRecordVersion(ctx, 1, WriteOptions{ExpectedVersion: &current}); err != nil {
+			t.Fatalf("DeleteRecordVersion: %v", err)
+		}
+		if _, err := svc.RestoreRecord(ctx, 1, WriteOptions{ExpectedVersion: &current}); err != ErrVersionConflict {
+			t.Fatalf("expected ErrVersionConflict, got %v", err)
+		}
+		if _, err := svc.CreateRecordVersion(ctx, 1, nil, WriteOptions{ExpectedVersion: &stale}); err != ErrVersionConflict {
+			t.Fatalf("expected ErrVersionConflict, got %v", err)
+		}
+	})
+
+	t.Run("PointInTime", func(t *testing.T) {
+		svc := newService(t)
+
+		january, march := base-60*86400000, base-30*86400000
+		if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"limit": "1M"}, WriteOptions{createdAtMS: base, EffectiveFromMS: &january}); err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+		// A correction recorded later, effective from March.
+		if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"limit": "2M"}, WriteOptions{createdAtMS: base + 100, EffectiveFromMS: &march}); err != nil {
+			t.Fatalf("UpdateRecordVersion: %v", err)
+		}
+		if _, err := svc.DeleteRecordVersion(ctx, 1, at(200)); err != nil {
+			t.Fatalf("DeleteRecordVersion: %v", err)
+		}
+
+		for _, tc := range []struct {
+			atMS    int64
+			version int
+			err     error
+		}{
+			{base - 1, 0, ErrRecordDoesNotExist},
+			{base, 1, nil},
+			{base + 150, 2, nil},
+			{base + 200, 0, ErrRecordDoesNotExist},
+		} {
+			got, err := svc.GetRecordVersionAt(ctx, 1, tc.atMS)
+			if err != tc.err || got.Version != tc.version {
+				t.Fatalf("at %d: got version %d, %v; want %d, %v", tc.atMS, got.Version, err, tc.version, tc.err)
+			}
+		}
+
+		for _, tc := range []struct {
+			validAtMS, asOfMS int64
+			version           int
+			err               error
+		}{
+			{march + 1, base + 50, 1, nil},
+			{march + 1, base + 150, 2, nil},
+			{march - 1, base + 150, 1, nil},
+			{january - 1, base + 150, 0, ErrRecordDoesNotExist},
+			{base + 250, base + 250, 0, ErrRecordDoesNotExist},
+		} {
+			got, err := svc.GetRecordVersionAsOf(ctx, 1, tc.validAtMS, tc.asOfMS)
+			if err != tc.err || got.Version != tc.version {
+				t.Fatalf("valid at %d as of %d: got version %d, %v; want %d, %v", tc.validAtMS, tc.asOfMS, got.Version, err, tc.version, tc.err)
+			}
+		}
+
+		if _, err := svc.GetRecordVersion(ctx, 1, 3); err != nil {
+			t.Fatalf("GetRecordVersion: %v", err)
+		}
+		if _, err := svc.GetRecordVersion(ctx, 1, 4); err != ErrRecordVersionDoesNotExist {
+			t.Fatalf("expected ErrRecordVersionDoesNotExist, got %v", err)
+		}
+
+		if _, err := svc.UpdateRecordVersion(ctx, 2, nil, WriteOptions{}); err != ErrRecordDoesNotExist {
+			t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+		}
+		if _, err := svc.CreateRecordVersion(ctx, 2, nil, WriteOptions{EffectiveFromMS: &march, EffectiveToMS: &january}); err != ErrEffectiveRangeInvalid {
+			t.Fatalf("expected ErrEffectiveRangeInvalid, got %v", err)
+		}
+		ranged, err := svc.CreateRecordVersion(ctx, 2, nil, WriteOptions{createdAtMS: base, EffectiveFromMS: &january, EffectiveToMS: &march})
+		if err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+		if ranged.EffectiveToMS == nil || *ranged.EffectiveToMS != march {
+			t.Fatalf("unexpected effective range: %+v", ranged)
+		}
+		if _, err := svc.GetRecordVersionAsOf(ctx, 2, march, base); err != ErrRecordDoesNotExist {
+			t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+		}
+	})
+
+	t.Run("BackdatedUpdate", func(t *testing.T) {
+		svc := newService(t)
+
+		january, march, april, may := base-150*86400000, base-90*86400000, base-60*86400000, base-30*86400000
+		if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"hours": "9-5"}, WriteOptions{createdAtMS: base, EffectiveFromMS: &january}); err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+		if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"address": "new"}, WriteOptions{createdAtMS: base + 100, EffectiveFromMS: &may}); err != nil {
+			t.Fatalf("UpdateRecordVersion: %v", err)
+		}
+
+		// Merged onto the latest version, a patch effective in March would
+		// carry May's address back into April.
+		if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"hours": "24/7"}, WriteOptions{createdAtMS: base + 200, EffectiveFromMS: &march}); err != ErrBackdatedUpdate {
+			t.Fatalf("expected ErrBackdatedUpdate, got %v", err)
+		}
+
+		got, err := svc.GetRecordVersionAsOf(ctx, 1, april, base+300)
+		if err != nil {
+			t.Fatalf("GetRecordVersionAsOf: %v", err)
+		}
+		if got.Version != 1 || got.Data["address"] != nil {
+			t.Fatalf("expected version 1 without May's fields, got %+v", got)
+		}
+
+	})
+
+	t.Run("ListRecordVersions", func(t *testing.T) {
+		svc := newService(t)
+
+		if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"n": json.Number("1")}, at(0)); err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+		for n := int64(2); n <= 5; n++ {
+			if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"n": json.Number(string(rune('0' + n)))}, at(n*10)); err != nil {
+				t.Fatalf("UpdateRecordVersion: %v", err)
+			}
+		}
+
+		var seen []int
+		opts := ListVersionsOptions{Limit: 2, Descending: true, OmitData: true}
+		for {
+			page, err := svc.ListRecordVersions(ctx, 1, opts)
+			if err != nil {
+				t.Fatalf("ListRecordVersions: %v", err)
+			}
+			for _, info := range page.Versions {
+				if info.Data != nil || info.Changes != nil {
+					t.Fatalf("expected data and changes to be omitted: %+v", info)
+				}
+				seen = append(seen, info.Version)
+			}
+			if page.NextCursor == nil {
+				break
+			}
+			opts.AfterVersion = *page.NextCursor
+		}
+		if !reflect.DeepEqual(seen, []int{5, 4, 3, 2, 1}) {
+			t.Fatalf("unexpected versions: %v", seen)
+		}
+
+		fromMS, toMS := base+20, base+40
+		page, err := svc.ListRecordVersions(ctx, 1, ListVersionsOptions{FromMS: &fromMS, ToMS: &toMS, IncludeChanges: true})
+		if err != nil {
+			t.Fatalf("ListRecordVersions: %v", err)
+		}
+		if len(page.Versions) != 2 || page.Versions[0].Version != 2 || page.Versions[0].Data["n"] != json.Number("2") || page.Versions[0].Changes["n"] != json.Number("2") {
+			t.Fatalf("unexpected page: %+v", page)
+		}
+
+		page, err = svc.ListRecordVersions(ctx, 1, ListVersionsOptions{AfterVersion: 5})
+		if err != nil || len(page.Versions) != 0 || page.NextCursor != nil {
+			t.Fatalf("expected an empty page, got %+v, %v", page, err)
+		}
+		if _, err := svc.ListRecordVersions(ctx, 1, ListVersionsOptions{Limit: -1}); err != ErrListOptionsInvalid {
+			t.Fatalf("expected ErrListOptionsInvalid, got %v", err)
+		}
+		if _, err := svc.ListRecordVersions(ctx, 2, ListVersionsOptions{}); err != ErrRecordDoesNotExist {
+			t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+		}
+	})
+
+	t.Run("ListRecords", func(t *testing.T) {
+		svc := newService(t)
+
+		for id, data := range map[int]map[string]interface{}{
+			1: {"state": "CA", "employees": json.Number("5")},
+			2: {"state": "NY", "employees": json.Number("5")},
+			3: {"state": "CA", "active": true},
+			4: {"state": "CA"},
+		} {
+			if _, err := svc.CreateRecordVersion(ctx, id, data, at(int64(id))); err != nil {
+				t.Fatalf("CreateRecordVersion: %v", err)
+			}
+		}
+		if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"state": "OR"}, at(10)); err != nil {
+			t.Fatalf("UpdateRecordVersion: %v", err)
+		}
+		if _, err := svc.DeleteRecordVersion(ctx, 4, at(10)); err != nil {
+			t.Fatalf("DeleteRecordVersion: %v", err)
+		}
+
+		ids := func(list entity.RecordList) []int {
+			result := []int{}
+			for _, recordVersion := range list.Records {
+				result = append(result, recordVersion.ID)
+			}
+			return result
+		}
+
+		var seen []int
+		opts := ListRecordsOptions{Limit: 2}
+		for {
+			page, err := svc.ListRecords(ctx, opts)
+			if err != nil {
+				t.Fatalf("ListRecords: %v", err)
+			}
+			seen = append(seen, ids(page)...)
+			if page.NextCursor == nil {
+				break
+			}
+			opts.AfterID = *page.NextCursor
+		}
+		if !reflect.DeepEqual(seen, []int{1, 2, 3}) {
+			t.Fatalf("unexpected records: %v", seen)
+		}
+
+		earlier := base + 5
+		for _, tc := range []struct {
+			opts ListRecordsOptions
+			want []int
+		}{
+			{ListRecordsOptions{Where: map[string]string{"state": "CA"}}, []int{3}},
+			{ListRecordsOptions{Where: map[string]string{"state": "CA"}, AtMS: &earlier}, []int{1, 3, 4}},
+			{ListRecordsOptions{Where: map[string]string{"employees": "5"}}, []int{1, 2}},
+			{ListRecordsOptions{Where: map[string]string{"employees": "5", "state": "NY"}}, []int{2}},
+			{ListRecordsOptions{Where: map[string]string{"active": "true"}}, []int{3}},
+			{ListRecordsOptions{Where: map[string]string{"missing": "x"}}, []int{}},
+		} {
+			list, err := svc.ListRecords(ctx, tc.opts)
+			if err != nil {
+				t.Fatalf("ListRecords: %v", err)
+			}
+			if got := ids(list); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("%+v: got %v, want %v", tc.opts, got, tc.want)
+			}
+		}
+
+		if _, err := svc.ListRecords(ctx, ListRecordsOptions{Where: map[string]string{`a"b`: "x"}}); err != ErrDataKeyInvalid {
+			t.Fatalf("expected ErrDataKeyInvalid, got %v", err)
+		}
+		if _, err := svc.ListRecords(ctx, ListRecordsOptions{AfterID: -1}); err != ErrListOptionsInvalid {
+			t.Fatalf("expected ErrListOptionsInvalid, got %v", err)
+		}
+
+		var snapshot []string
+		if err := svc.SnapshotAt(ctx, earlier, func(recordVersion entity.RecordVersion) error {
+			snapshot = append(snapshot, recordVersion.Data["state"].(string))
+			return nil
+		}); err != nil {
+			t.Fatalf("SnapshotAt: %v", err)
+		}
+		if !reflect.DeepEqual(snapshot, []string{"CA", "NY", "CA", "CA"}) {
+			t.Fatalf("unexpected snapshot: %v", snapshot)
+		}
+
+		// Numbers match their JSON text, not their value.
+		if _, err := svc.CreateRecordVersion(ctx, 5, map[string]interface{}{"employees": json.Number("5.0")}, at(20)); err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+		for value, want := range map[string][]int{"5": {1, 2}, "5.0": {5}} {
+			list, err := svc.ListRecords(ctx, ListRecordsOptions{Where: map[string]string{"employees": value}})
+			if err != nil {
+				t.Fatalf("ListRecords: %v", err)
+			}
+			if got := ids(list); !reflect.DeepEqual(got, want) {
+				t.Fatalf("employees %s: got %v, want %v", value, got, want)
+			}
+		}
+	})
+
+	t.Run("FieldHistoryAndDiff", func(t *testing.T) {
+		svc := newService(t)
+
+		if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"employees": json.Number("10"), "state": "CA"}, WriteOptions{}); err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+		for _, updates := range []map[string]interface{}{
+			{"other": "x"},
+			{"employees": json.Number("12")},
+			{"employees": nil},
+			{"employees": json.Number("10"), "state": "NY"},
+		} {
+			if _, err := svc.UpdateRecordVersion(ctx, 1, updates, WriteOptions{}); err != nil {
+				t.Fatalf("UpdateRecordVersion: %v", err)
+			}
+		}
+
+		history, err := svc.FieldHistory(ctx, 1, "employees")
+		if err != nil {
+			t.Fatalf("FieldHistory: %v", err)
+		}
+		var got []interface{}
+		for _, change := range history.Changes {
+			if change.Removed {
+				got = append(got, change.Version, "<removed>")
+			} else {
+				got = append(got, change.Version, change.Value)
+			}
+		}
+		want := []interface{}{1, json.Number("10"), 3, json.Number("12"), 4, "<removed>", 5, json.Number("10")}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if _, err := svc.FieldHistory(ctx, 1, ""); err != ErrDataKeyInvalid {
+			t.Fatalf("expected ErrDataKeyInvalid, got %v", err)
+		}
+		if _, err := svc.FieldHistory(ctx, 2, "employees"); err != ErrRecordDoesNotExist {
+			t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+		}
+
+		diff, err := svc.DiffRecordVersions(ctx, 1, 1, 5)
+		if err != nil {
+			t.Fatalf("DiffRecordVersions: %v", err)
+		}
+		wantDiff := entity.RecordDiff{
+			ID:          1,
+			FromVersion: 1,
+			ToVersion:   5,
+			Added:       map[string]interface{}{"other": "x"},
+			Removed:     map[string]interface{}{},
+			Changed:     map[string]entity.ValueChange{"state": {Old: "CA", New: "NY"}},
+		}
+		if !reflect.DeepEqual(diff, wantDiff) {
+			t.Fatalf("got %+v, want %+v", diff, wantDiff)
+		}
+		if _, err := svc.DiffRecordVersions(ctx, 1, 1, 6); err != ErrRecordVersionDoesNotExist {
+			t.Fatalf("expected ErrRecordVersionDoesNotExist, got %v", err)
+		}
+	})
+
+	t.Run("InvalidIDs", func(t *testing.T) {
+		svc := newService(t)
+
+		for name, call := range map[string]func() error{
+			"GetRecord":              func() error { _, err := svc.GetRecord(ctx, 0); return err },
+			"GetLatestRecordVersion": func() error { _, err := svc.GetLatestRecordVersion(ctx, -1); return err },
+			"GetRecordVersion":       func() error { _, err := svc.GetRecordVersion(ctx, 1, 0); return err },
+			"CreateRecordVersion": func() error {
+				_, err := svc.CreateRecordVersion(ctx, 0, nil, WriteOptions{})
+				return err
+			},
+			"UpdateRecordVersion": func() error {
+				_, err := svc.UpdateRecordVersion(ctx, 0, nil, WriteOptions{})
+				return err
+			},
+			"RestoreRecord": func() error { _, err := svc.RestoreRecord(ctx, 0, WriteOptions{}); return err },
+		} {
+			if err := call(); err != ErrRecordIDInvalid {
+				t.Fatalf("%s: expected ErrRecordIDInvalid, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestDBRecordService_Conformance(t *testing.T) {
+	testVersionedRecordService(t, func(t *testing.T) VersionedRecordService {
+		return newTestDBRecordService(t)
+	})
+}
+
+func TestMemoryRecordService_Conformance(t *testing.T) {
+	testVersionedRecordService(t, func(t *testing.T) VersionedRecordService {
+		return NewMemoryRecordService()
+	})
+}
+
+// TestPostgresRecordService_Conformance runs against the database in
+// TIMETRAVEL_TEST_POSTGRES_DSN, e.g.
+// "postgres://localhost/timetravel_test?sslmode=disable". Every record in it
+// is deleted.
+func TestPostgresRecordService_Conformance(t *testing.T) {
+	dsn := os.Getenv("TIMETRAVEL_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TIMETRAVEL_TEST_POSTGRES_DSN is not set")
+	}
+
+	testVersionedRecordService(t, func(t *testing.T) VersionedRecordService {
+		svc, err := NewPostgresRecordService(dsn)
+		if err != nil {
+			t.Fatalf("NewPostgresRecordService: %v", err)
+		}
+		t.Cleanup(func() { _ = svc.Close() })
+		if _, err := svc.db.Exec(`TRUNCATE record_versions`); err != nil {
+			t.Fatalf("TRUNCATE: %v", err)
+		}
+		return svc
+	})
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// encodeVersionJSON is the stored JSON text of a version's data and change set.
+func encodeVersionJSON(recordVersion entity.RecordVersion) (string, string, error) {
+	dataJSON, err := json.Marshal(recordVersion.Data)
+	if err != nil {
+		return "", "", err
+	}
+	changes := recordVersion.Changes
+	if changes == nil {
+		changes = map[string]interface{}{}
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return "", "", err
+	}
+	return string(dataJSON), string(changesJSON), nil
+}
+
+// decodeJSONObject decodes a stored JSON object. Numbers are kept as
+// json.Number so large integers and decimals round-trip exactly.
+func decodeJSONObject(raw string) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewBufferString(raw))
+	decoder.UseNumber()
+
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// decodeJSONValue decodes a single stored JSON value, keeping numbers as json.Number.
+func decodeJSONValue(raw string) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewBufferString(raw))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// stringifyData renders typed data for the string-only v1 API: strings are
+// kept as-is and every other value becomes its JSON encoding.
+func stringifyData(data map[string]interface{}) (map[string]string, error) {
+	result := make(map[string]string, len(data))
+	for key, value := range data {
+		if s, ok := value.(string); ok {
+			result[key] = s
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = string(encoded)
+	}
+	return result, nil
+}
+
+// V1Record renders a version as the v1 API returns records, with
+// stringifyData's string-only data.
+func V1Record(recordVersion entity.RecordVersion) (entity.Record, error) {
+	data, err := stringifyData(recordVersion.Data)
+	if err != nil {
+		return entity.Record{}, err
+	}
+	return entity.Record{ID: recordVersion.ID, Data: data}, nil
+}
+
+// typedData converts v1 string data to typed data.
+func typedData(data map[string]string) map[string]interface{} {
+	result := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		result[key] = value
+	}
+	return result
+}
+
+// TypedUpdates converts a v1 patch, whose values are strings or null, to a typed patch.
+func TypedUpdates(updates map[string]*string) map[string]interface{} {
+	result := make(map[string]interface{}, len(updates))
+	for key, value := range updates {
+		if value == nil {
+			result[key] = nil
+		} else {
+			result[key] = *value
+		}
+	}
+	return result
+}
+
+// applyUpdates merges a patch into data; a nil value deletes its key.
+func applyUpdates(data map[string]interface{}, updates map[string]interface{}) {
+	for key, value := range updates {
+		if value == nil {
+			delete(data, key)
+		} else {
+			data[key] = value
+		}
+	}
+}
+
+// changesBetween returns the patch that turns from into to.
+func changesBetween(from map[string]interface{}, to map[string]interface{}) map[string]interface{} {
+	changes := map[string]interface{}{}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			changes[key] = nil
+		}
+	}
+	for key, value := range to {
+		if oldValue, ok := from[key]; !ok || !reflect.DeepEqual(oldValue, value) {
+			changes[key] = value
+		}
+	}
+	return changes
+}
@@ -2,8 +2,8 @@ package service
 
 import (
 	"context"
+	"crypto/ed25519"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -14,8 +14,37 @@ import (
 	"github.com/rainbowmga/timetravel/entity"
 )
 
+// recordVersionColumns is the column list scanned by scanRecordVersion.
+var recordVersionColumns = recordVersionColumnList(true, true)
+
+// recordVersionColumnList builds a column list scanned by scanRecordVersion
+// that only reads the data and changes columns when they are wanted. Without
+// them, versions scan with empty data and nil changes.
+func recordVersionColumnList(data, changes bool) string {
+	dataColumn, changesColumn := `'{}'`, `NULL`
+	if data {
+		dataColumn = `data_json`
+	}
+	if changes {
+		changesColumn = `changes_json`
+	}
+	return `record_id, version, created_at_ms, effective_from_ms, effective_to_ms, ` + dataColumn + `, ` + changesColumn + `, reverted_to_version, deleted, actor, reason, transaction_id`
+}
+
+// DBRecordService stores records in SQLite. Each value serves a single
+// collection; use Collection to reach the others.
 type DBRecordService struct {
 	db *sql.DB
+	// readDB serves long reads, such as snapshots and exports, from their
+	// own connections so they don't hold db's single connection and its
+	// write lock while a client consumes them.
+	readDB        *sql.DB
+	schemas       *schemaCache
+	collection    string
+	checkpointKey ed25519.PrivateKey
+
+	// allowPrivateWebhooks lets tests send webhooks to local servers.
+	allowPrivateWebhooks bool
 }
 
 func NewDBRecordService(dbPath string) (*DBRecordService, error) {
@@ -23,7 +52,8 @@ func NewDBRecordService(dbPath string) (*DBRecordService, error) {
 		return nil, fmt.Errorf("dbPath is required")
 	}
 
-	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_txlock=immediate", dbPath)
+	// WAL lets readDB's transactions read a consistent snapshot while db writes.
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_txlock=immediate&_journal_mode=WAL", dbPath)
 	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, err
@@ -35,26 +65,59 @@ func NewDBRecordService(dbPath string) (*DBRecordService, error) {
 		return nil, err
 	}
 
-	return &DBRecordService{db: db}, nil
+	readDSN := fmt.Sprintf("file:%s?_busy_timeout=5000&_query_only=true", dbPath)
+	readDB, err := sql.Open("sqlite3", readDSN)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &DBRecordService{db: db, readDB: readDB, schemas: newSchemaCache(), collection: DefaultCollection}, nil
 }
 
 func (s *DBRecordService) Close() error {
-	return s.db.Close()
+	readErr := s.readDB.Close()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return readErr
 }
 
-func initSchema(db *sql.DB) error {
-	if _, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS record_versions (
-			record_id     INTEGER NOT NULL,
-			version       INTEGER NOT NULL,
-			data_json     TEXT NOT NULL,
-			created_at_ms INTEGER NOT NULL DEFAULT (
+// recordVersionsDefinition is the body of the CREATE TABLE statement for record_versions.
+const recordVersionsDefinition = `
+			collection          TEXT NOT NULL DEFAULT 'records',
+			record_id           INTEGER NOT NULL,
+			version             INTEGER NOT NULL,
+			data_json           TEXT NOT NULL,
+			created_at_ms       INTEGER NOT NULL DEFAULT (
 				(CAST(strftime('%s','now') AS INTEGER) * 1000) +
 				CAST((strftime('%f','now') - strftime('%S','now')) * 1000 AS INTEGER)
 			),
-			PRIMARY KEY (record_id, version)
-		)
-	`); err != nil {
+			effective_from_ms   INTEGER,
+			effective_to_ms     INTEGER,
+			changes_json        TEXT,
+			reverted_to_version INTEGER,
+			deleted             INTEGER NOT NULL DEFAULT 0,
+			actor               TEXT,
+			reason              TEXT,
+			transaction_id      INTEGER,
+			seq                 INTEGER,
+			record_hash         TEXT,
+			chain_hash          TEXT,
+			PRIMARY KEY (collection, record_id, version)
+`
+
+// recordSchemasDefinition is the body of the CREATE TABLE statement for record_schemas.
+const recordSchemasDefinition = `
+			collection     TEXT NOT NULL DEFAULT 'records',
+			record_id      INTEGER NOT NULL,
+			schema_name    TEXT NOT NULL,
+			schema_version INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (collection, record_id)
+`
+
+func initSchema(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS record_versions (` + recordVersionsDefinition + `)`); err != nil {
 		return err
 	}
 
@@ -74,6 +137,41 @@ func initSchema(db *sql.DB) error {
 		}
 	}
 
+	// Columns added after the table was first created. They are nullable so
+	// they can be added to existing databases and backfilled below.
+	for _, column := range []struct{ name, definition string }{
+		// Bitemporal columns: created_at_ms is when we learned about a change,
+		// effective_from_ms/effective_to_ms is when it was true in the real world.
+		{"effective_from_ms", "INTEGER"},
+		{"effective_to_ms", "INTEGER"},
+		// The patch each version was written from; NULL for versions that predate it.
+		{"changes_json", "TEXT"},
+		{"reverted_to_version", "INTEGER"},
+		// Tombstones left by deleting a record.
+		{"deleted", "INTEGER NOT NULL DEFAULT 0"},
+		// Who made a change and why.
+		{"actor", "TEXT"},
+		{"reason", "TEXT"},
+		// The transaction a version was written in, if it was part of one.
+		{"transaction_id", "INTEGER"},
+		// Position in the change feed, across every collection. Seqs run from
+		// 1 without gaps: each write takes the next one under the write lock
+		// and versions are never deleted. Checkpoints rely on this.
+		{"seq", "INTEGER"},
+		// Hash chains that make edits to history detectable; see VerifyService.
+		{"record_hash", "TEXT"},
+		{"chain_hash", "TEXT"},
+	} {
+		if err := addColumnIfMissing(db, "record_versions", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	// Tables created before collections existed keyed records by id alone.
+	if err := addCollectionToKey(db, "record_versions", recordVersionsDefinition); err != nil {
+		return err
+	}
+
 	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_record_versions_created_at_ms ON record_versions (created_at_ms)`); err != nil {
 		return err
 	}
@@ -83,6 +181,154 @@ func initSchema(db *sql.DB) error {
 		return err
 	}
 
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_record_versions_effective_from_ms ON record_versions (collection, record_id, effective_from_ms)`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_record_versions_transaction_id ON record_versions (transaction_id)`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_record_versions_seq ON record_versions (seq)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS transactions (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at_ms INTEGER NOT NULL,
+			actor         TEXT,
+			reason        TEXT
+		)
+	`); err != nil {
+		return err
+	}
+
+	// Registered JSON Schemas and the records validated against them.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schemas (
+			name          TEXT NOT NULL,
+			version       INTEGER NOT NULL,
+			schema_json   TEXT NOT NULL,
+			created_at_ms INTEGER NOT NULL,
+			PRIMARY KEY (name, version)
+		)
+	`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS record_schemas (` + recordSchemasDefinition + `)`); err != nil {
+		return err
+	}
+	if err := addCollectionToKey(db, "record_schemas", recordSchemasDefinition); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS collections (
+			name           TEXT PRIMARY KEY,
+			schema_name    TEXT,
+			schema_version INTEGER NOT NULL DEFAULT 0,
+			created_at_ms  INTEGER NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(
+		`INSERT OR IGNORE INTO collections (name, created_at_ms) VALUES (?, ?)`,
+		DefaultCollection,
+		time.Now().UTC().UnixMilli(),
+	); err != nil {
+		return err
+	}
+
+	// Typed links between records. Like record_versions, every change to a
+	// link appends a version, so links can be read as of any time.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS record_links (
+			collection        TEXT NOT NULL,
+			record_id         INTEGER NOT NULL,
+			link_type         TEXT NOT NULL,
+			target_collection TEXT NOT NULL,
+			target_id         INTEGER NOT NULL,
+			version           INTEGER NOT NULL,
+			created_at_ms     INTEGER NOT NULL,
+			removed           INTEGER NOT NULL DEFAULT 0,
+			actor             TEXT,
+			reason            TEXT,
+			PRIMARY KEY (collection, record_id, link_type, target_collection, target_id, version)
+		)
+	`); err != nil {
+		return err
+	}
+
+	// Webhook subscribers and their outbox. A delivery is queued in the same
+	// transaction as the version it announces, so none is lost if the process
+	// stops before the webhook is called.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			url           TEXT NOT NULL,
+			secret        TEXT NOT NULL,
+			collection    TEXT,
+			created_at_ms INTEGER NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id         INTEGER NOT NULL,
+			seq                INTEGER NOT NULL,
+			status             TEXT NOT NULL DEFAULT 'pending',
+			attempts           INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at_ms INTEGER NOT NULL,
+			last_attempt_at_ms INTEGER,
+			last_error         TEXT,
+			created_at_ms      INTEGER NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries (status, webhook_id, id)`); err != nil {
+		return err
+	}
+
+	// Signed Merkle roots over record_versions; see CheckpointService.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS checkpoints (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			tree_size     INTEGER NOT NULL,
+			root_hash     TEXT NOT NULL,
+			created_at_ms INTEGER NOT NULL,
+			public_key    TEXT NOT NULL,
+			signature     TEXT NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+
+	// The hash of every complete subtree of the checkpoint tree; see merkleTree.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS merkle_nodes (
+			level INTEGER NOT NULL,
+			idx   INTEGER NOT NULL,
+			hash  BLOB NOT NULL,
+			PRIMARY KEY (level, idx)
+		)
+	`); err != nil {
+		return err
+	}
+
+	// One-off data migrations that have already run, by name.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS migrations (
+			name          TEXT PRIMARY KEY,
+			applied_at_ms INTEGER NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+
 	// Migration from the earlier Objective #1 schema (single-row `records` table).
 	if _, err := db.Exec(`
 		INSERT OR IGNORE INTO record_versions (record_id, version, data_json)
@@ -92,6 +338,36 @@ func initSchema(db *sql.DB) error {
 		return err
 	}
 
+	// Versions written before effective time existed took effect when they were recorded.
+	if _, err := db.Exec(`UPDATE record_versions SET effective_from_ms = created_at_ms WHERE effective_from_ms IS NULL`); err != nil {
+		return err
+	}
+
+	// Versions written before the change feed existed join it after every
+	// numbered version, in the order they were recorded.
+	if _, err := db.Exec(`
+		UPDATE record_versions SET seq = numbered.base + numbered.n
+		FROM (
+			SELECT collection, record_id, version,
+				(SELECT COALESCE(MAX(seq), 0) FROM record_versions) AS base,
+				ROW_NUMBER() OVER (ORDER BY created_at_ms, collection, record_id, version) AS n
+			FROM record_versions
+			WHERE seq IS NULL
+		) AS numbered
+		WHERE record_versions.collection = numbered.collection
+			AND record_versions.record_id = numbered.record_id
+			AND record_versions.version = numbered.version
+	`); err != nil {
+		return err
+	}
+
+	if err := backfillVersionHashes(db); err != nil {
+		return err
+	}
+	if err := storeCheckpointedMerkleNodes(db); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -108,30 +384,146 @@ func hasColumn(db *sql.DB, tableName, columnName string) (bool, error) {
 	return false, err
 }
 
-func (s *DBRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
-	if id <= 0 {
-		return entity.Record{}, ErrRecordIDInvalid
+// addCollectionToKey rebuilds a table from before collections existed so that
+// its primary key starts with the collection; SQLite cannot change a primary
+// key in place. Existing rows move to the default collection.
+func addCollectionToKey(db *sql.DB, tableName, definition string) error {
+	exists, err := hasColumn(db, tableName, "collection")
+	if err != nil || exists {
+		return err
 	}
 
-	var dataJSON string
-	err := s.db.QueryRowContext(
-		ctx,
-		`SELECT data_json FROM record_versions WHERE record_id = ? ORDER BY version DESC LIMIT 1`,
-		id,
-	).Scan(&dataJSON)
+	rows, err := db.Query(fmt.Sprintf(`SELECT name FROM pragma_table_info('%s')`, tableName))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return entity.Record{}, ErrRecordDoesNotExist
+		return err
+	}
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			_ = rows.Close()
+			return err
 		}
-		return entity.Record{}, err
+		columns = append(columns, column)
+	}
+	if err := rows.Close(); err != nil {
+		return err
 	}
+	columnList := strings.Join(columns, ", ")
 
-	var data map[string]string
-	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-		return entity.Record{}, err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, statement := range []string{
+		fmt.Sprintf(`CREATE TABLE %s_rebuild (%s)`, tableName, definition),
+		fmt.Sprintf(`INSERT INTO %s_rebuild (%s) SELECT %s FROM %s`, tableName, columnList, columnList, tableName),
+		fmt.Sprintf(`DROP TABLE %s`, tableName),
+		fmt.Sprintf(`ALTER TABLE %s_rebuild RENAME TO %s`, tableName, tableName),
+	} {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already there.
+func addColumnIfMissing(db *sql.DB, tableName, columnName, definition string) error {
+	exists, err := hasColumn(db, tableName, columnName)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, tableName, columnName, definition))
+	return err
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	queryRower
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// scanRecordVersion scans a row selected with recordVersionColumns.
+func scanRecordVersion(row rowScanner) (entity.RecordVersion, error) {
+	var recordVersion entity.RecordVersion
+	var (
+		effectiveToMS sql.NullInt64
+		dataJSON      string
+		changesJSON   sql.NullString
+		revertedTo    sql.NullInt64
+		actor         sql.NullString
+		reason        sql.NullString
+		transactionID sql.NullInt64
+	)
+	if err := row.Scan(
+		&recordVersion.ID,
+		&recordVersion.Version,
+		&recordVersion.CreatedAtMS,
+		&recordVersion.EffectiveFromMS,
+		&effectiveToMS,
+		&dataJSON,
+		&changesJSON,
+		&revertedTo,
+		&recordVersion.Deleted,
+		&actor,
+		&reason,
+		&transactionID,
+	); err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if effectiveToMS.Valid {
+		recordVersion.EffectiveToMS = &effectiveToMS.Int64
+	}
+
+	data, err := decodeJSONObject(dataJSON)
+	if err != nil {
+		return entity.RecordVersion{}, err
 	}
 	if data == nil {
-		data = map[string]string{}
+		data = map[string]interface{}{}
+	}
+	recordVersion.Data = data
+
+	recordVersion.Actor = actor.String
+	recordVersion.Reason = reason.String
+	recordVersion.TransactionID = int(transactionID.Int64)
+	if revertedTo.Valid {
+		revertedToVersion := int(revertedTo.Int64)
+		recordVersion.RevertedToVersion = &revertedToVersion
+	}
+	if changesJSON.Valid {
+		changes, err := decodeJSONObject(changesJSON.String)
+		if err != nil {
+			return entity.RecordVersion{}, err
+		}
+		recordVersion.Changes = changes
+	}
+
+	return recordVersion, nil
+}
+
+func (s *DBRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	recordVersion, err := s.GetLatestRecordVersion(ctx, id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	data, err := stringifyData(recordVersion.Data)
+	if err != nil {
+		return entity.Record{}, err
 	}
 
 	return entity.Record{ID: id, Data: data}, nil
@@ -142,16 +534,13 @@ func (s *DBRecordService) GetLatestRecordVersion(ctx context.Context, id int) (e
 		return entity.RecordVersion{}, ErrRecordIDInvalid
 	}
 
-	var (
-		version     int
-		dataJSON    string
-		createdAtMS int64
-	)
-	err := s.db.QueryRowContext(
+	row := s.db.QueryRowContext(
 		ctx,
-		`SELECT version, data_json, created_at_ms FROM record_versions WHERE record_id = ? ORDER BY version DESC LIMIT 1`,
+		`SELECT `+recordVersionColumns+` FROM record_versions WHERE collection = ? AND record_id = ? ORDER BY version DESC LIMIT 1`,
+		s.collection,
 		id,
-	).Scan(&version, &dataJSON, &createdAtMS)
+	)
+	recordVersion, err := scanRecordVersion(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return entity.RecordVersion{}, ErrRecordDoesNotExist
@@ -159,37 +548,34 @@ func (s *DBRecordService) GetLatestRecordVersion(ctx context.Context, id int) (e
 		return entity.RecordVersion{}, err
 	}
 
-	var data map[string]string
-	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-		return entity.RecordVersion{}, err
-	}
-	if data == nil {
-		data = map[string]string{}
+	if recordVersion.Deleted {
+		return entity.RecordVersion{}, ErrRecordDoesNotExist
 	}
 
-	return entity.RecordVersion{ID: id, Version: version, CreatedAtMS: createdAtMS, Data: data}, nil
+	return recordVersion, nil
 }
 
 func (s *DBRecordService) GetRecordVersionAt(ctx context.Context, id int, atMS int64) (entity.RecordVersion, error) {
 	if id <= 0 {
 		return entity.RecordVersion{}, ErrRecordIDInvalid
 	}
+	return s.recordVersionAt(ctx, s.db, id, atMS)
+}
 
-	var (
-		version     int
-		dataJSON    string
-		createdAtMS int64
-	)
-	err := s.db.QueryRowContext(
+// recordVersionAt reads the version of a record that was current at atMS.
+func (s *DBRecordService) recordVersionAt(ctx context.Context, db queryRower, id int, atMS int64) (entity.RecordVersion, error) {
+	row := db.QueryRowContext(
 		ctx,
-		`SELECT version, data_json, created_at_ms
+		`SELECT `+recordVersionColumns+`
 		 FROM record_versions
-		 WHERE record_id = ? AND created_at_ms <= ?
+		 WHERE collection = ? AND record_id = ? AND created_at_ms <= ?
 		 ORDER BY created_at_ms DESC, version DESC
 		 LIMIT 1`,
+		s.collection,
 		id,
 		atMS,
-	).Scan(&version, &dataJSON, &createdAtMS)
+	)
+	recordVersion, err := scanRecordVersion(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return entity.RecordVersion{}, ErrRecordDoesNotExist
@@ -197,15 +583,49 @@ func (s *DBRecordService) GetRecordVersionAt(ctx context.Context, id int, atMS i
 		return entity.RecordVersion{}, err
 	}
 
-	var data map[string]string
-	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
+	if recordVersion.Deleted {
+		return entity.RecordVersion{}, ErrRecordDoesNotExist
+	}
+
+	return recordVersion, nil
+}
+
+func (s *DBRecordService) GetRecordVersionAsOf(ctx context.Context, id int, validAtMS int64, asOfMS int64) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	// Of the versions recorded by asOfMS whose effective range covers
+	// validAtMS, the most recently recorded one wins.
+	row := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+recordVersionColumns+`
+		 FROM record_versions
+		 WHERE collection = ? AND record_id = ?
+		   AND created_at_ms <= ?
+		   AND effective_from_ms <= ?
+		   AND (effective_to_ms IS NULL OR effective_to_ms > ?)
+		 ORDER BY created_at_ms DESC, version DESC
+		 LIMIT 1`,
+		s.collection,
+		id,
+		asOfMS,
+		validAtMS,
+		validAtMS,
+	)
+	recordVersion, err := scanRecordVersion(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.RecordVersion{}, ErrRecordDoesNotExist
+		}
 		return entity.RecordVersion{}, err
 	}
-	if data == nil {
-		data = map[string]string{}
+
+	if recordVersion.Deleted {
+		return entity.RecordVersion{}, ErrRecordDoesNotExist
 	}
 
-	return entity.RecordVersion{ID: id, Version: version, CreatedAtMS: createdAtMS, Data: data}, nil
+	return recordVersion, nil
 }
 
 func (s *DBRecordService) GetRecordVersion(ctx context.Context, id int, version int) (entity.RecordVersion, error) {
@@ -216,14 +636,14 @@ func (s *DBRecordService) GetRecordVersion(ctx context.Context, id int, version
 		return entity.RecordVersion{}, ErrRecordIDInvalid
 	}
 
-	var dataJSON string
-	var createdAtMS int64
-	err := s.db.QueryRowContext(
+	row := s.db.QueryRowContext(
 		ctx,
-		`SELECT data_json, created_at_ms FROM record_versions WHERE record_id = ? AND version = ? LIMIT 1`,
+		`SELECT `+recordVersionColumns+` FROM record_versions WHERE collection = ? AND record_id = ? AND version = ? LIMIT 1`,
+		s.collection,
 		id,
 		version,
-	).Scan(&dataJSON, &createdAtMS)
+	)
+	recordVersion, err := scanRecordVersion(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
@@ -231,27 +651,50 @@ func (s *DBRecordService) GetRecordVersion(ctx context.Context, id int, version
 		return entity.RecordVersion{}, err
 	}
 
-	var data map[string]string
-	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-		return entity.RecordVersion{}, err
-	}
-	if data == nil {
-		data = map[string]string{}
-	}
-
-	return entity.RecordVersion{ID: id, Version: version, CreatedAtMS: createdAtMS, Data: data}, nil
+	return recordVersion, nil
 }
 
-func (s *DBRecordService) ListRecordVersions(ctx context.Context, id int) (entity.RecordVersions, error) {
+func (s *DBRecordService) ListRecordVersions(ctx context.Context, id int, opts ListVersionsOptions) (entity.RecordVersions, error) {
 	if id <= 0 {
 		return entity.RecordVersions{}, ErrRecordIDInvalid
 	}
+	if opts.Limit < 0 || opts.AfterVersion < 0 {
+		return entity.RecordVersions{}, ErrListOptionsInvalid
+	}
 
-	rows, err := s.db.QueryContext(
-		ctx,
-		`SELECT version, created_at_ms, data_json FROM record_versions WHERE record_id = ? ORDER BY version ASC`,
-		id,
-	)
+	// Skip reading the JSON columns the caller did not ask for.
+	columns := recordVersionColumnList(!opts.OmitData, opts.IncludeChanges)
+
+	query := `SELECT ` + columns + ` FROM record_versions WHERE collection = ? AND record_id = ?`
+	args := []interface{}{s.collection, id}
+	if opts.AfterVersion > 0 {
+		if opts.Descending {
+			query += ` AND version < ?`
+		} else {
+			query += ` AND version > ?`
+		}
+		args = append(args, opts.AfterVersion)
+	}
+	if opts.FromMS != nil {
+		query += ` AND created_at_ms >= ?`
+		args = append(args, *opts.FromMS)
+	}
+	if opts.ToMS != nil {
+		query += ` AND created_at_ms < ?`
+		args = append(args, *opts.ToMS)
+	}
+	if opts.Descending {
+		query += ` ORDER BY version DESC`
+	} else {
+		query += ` ORDER BY version ASC`
+	}
+	if opts.Limit > 0 {
+		// One extra row tells us whether there is a next page.
+		query += ` LIMIT ?`
+		args = append(args, opts.Limit+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return entity.RecordVersions{}, err
 	}
@@ -259,16 +702,25 @@ func (s *DBRecordService) ListRecordVersions(ctx context.Context, id int) (entit
 
 	result := entity.RecordVersions{ID: id, Versions: []entity.RecordVersionInfo{}}
 	for rows.Next() {
-		var info entity.RecordVersionInfo
-		var dataJSON string
-		if err := rows.Scan(&info.Version, &info.CreatedAtMS, &dataJSON); err != nil {
+		recordVersion, err := scanRecordVersion(rows)
+		if err != nil {
 			return entity.RecordVersions{}, err
 		}
-		if err := json.Unmarshal([]byte(dataJSON), &info.Data); err != nil {
-			return entity.RecordVersions{}, err
+		info := entity.RecordVersionInfo{
+			Version:           recordVersion.Version,
+			CreatedAtMS:       recordVersion.CreatedAtMS,
+			EffectiveFromMS:   recordVersion.EffectiveFromMS,
+			EffectiveToMS:     recordVersion.EffectiveToMS,
+			Data:              recordVersion.Data,
+			Changes:           recordVersion.Changes,
+			RevertedToVersion: recordVersion.RevertedToVersion,
+			Deleted:           recordVersion.Deleted,
+			Actor:             recordVersion.Actor,
+			Reason:            recordVersion.Reason,
+			TransactionID:     recordVersion.TransactionID,
 		}
-		if info.Data == nil {
-			info.Data = map[string]string{}
+		if opts.OmitData {
+			info.Data = nil
 		}
 		result.Versions = append(result.Versions, info)
 	}
@@ -276,108 +728,431 @@ func (s *DBRecordService) ListRecordVersions(ctx context.Context, id int) (entit
 		return entity.RecordVersions{}, err
 	}
 
+	if opts.Limit > 0 && len(result.Versions) > opts.Limit {
+		result.Versions = result.Versions[:opts.Limit]
+		nextCursor := result.Versions[opts.Limit-1].Version
+		result.NextCursor = &nextCursor
+	}
+
+	// An empty page is only an error if the record has no versions at all.
 	if len(result.Versions) == 0 {
-		return entity.RecordVersions{}, ErrRecordDoesNotExist
+		var marker int
+		err := s.db.QueryRowContext(ctx, `SELECT 1 FROM record_versions WHERE collection = ? AND record_id = ? LIMIT 1`, s.collection, id).Scan(&marker)
+		if err == sql.ErrNoRows {
+			return entity.RecordVersions{}, ErrRecordDoesNotExist
+		}
+		if err != nil {
+			return entity.RecordVersions{}, err
+		}
 	}
 
 	return result, nil
 }
 
 func (s *DBRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
-	if record.ID <= 0 {
-		return ErrRecordIDInvalid
-	}
+	_, err := s.CreateRecordVersion(ctx, record.ID, typedData(record.Data), WriteOptions{})
+	return err
+}
 
-	data := record.Data
-	if data == nil {
-		data = map[string]string{}
+func (s *DBRecordService) CreateRecordVersion(ctx context.Context, id int, data map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.RecordVersion{}, err
 	}
-	dataJSONBytes, err := json.Marshal(data)
+	defer func() { _ = tx.Rollback() }()
+
+	recordVersion, err := s.createRecordVersion(ctx, tx, id, data, opts)
 	if err != nil {
-		return err
+		return entity.RecordVersion{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.RecordVersion{}, err
 	}
 
-	_, err = s.db.ExecContext(
-		ctx,
-		`INSERT INTO record_versions (record_id, version, created_at_ms, data_json) VALUES (?, 1, ?, ?)`,
-		record.ID,
-		time.Now().UTC().UnixMilli(),
-		string(dataJSONBytes),
-	)
+	return recordVersion, nil
+}
+
+// createRecordVersion is CreateRecordVersion inside tx.
+func (s *DBRecordService) createRecordVersion(ctx context.Context, tx *sql.Tx, id int, data map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	data, changes := splitCreateData(data)
+
+	// A deleted record can be created again; its history carries on.
+	current, err := s.latestRecordVersion(ctx, tx, id)
+	switch {
+	case err == ErrRecordDoesNotExist:
+		current = entity.RecordVersion{ID: id}
+	case err != nil:
+		return entity.RecordVersion{}, err
+	case !current.Deleted:
+		return entity.RecordVersion{}, ErrRecordAlreadyExists
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	recordVersion, err := s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{Data: data, Changes: changes}, opts)
 	if err != nil {
 		var sqliteErr sqlite3.Error
 		if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
-			return ErrRecordAlreadyExists
+			return entity.RecordVersion{}, ErrRecordAlreadyExists
 		}
-		return err
+		return entity.RecordVersion{}, err
 	}
-	return nil
+	return recordVersion, nil
+}
+
+// putRecordVersion creates a record inside tx, or replaces all of its data
+// if it exists.
+func (s *DBRecordService) putRecordVersion(ctx context.Context, tx *sql.Tx, id int, data map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	current, err := s.latestLiveRecordVersion(ctx, tx, id)
+	if err == ErrRecordDoesNotExist {
+		return s.createRecordVersion(ctx, tx, id, data, opts)
+	}
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	next := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if value != nil {
+			next[key] = value
+		}
+	}
+	return s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{Data: next, Changes: changesBetween(current.Data, next)}, opts)
 }
 
 func (s *DBRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	recordVersion, err := s.UpdateRecordVersion(ctx, id, TypedUpdates(updates), WriteOptions{})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	data, err := stringifyData(recordVersion.Data)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{ID: id, Data: data}, nil
+}
+
+func (s *DBRecordService) UpdateRecordVersion(ctx context.Context, id int, updates map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	recordVersion, err := s.updateRecordVersion(ctx, tx, id, updates, opts)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	return recordVersion, nil
+}
+
+// updateRecordVersion is UpdateRecordVersion inside tx.
+func (s *DBRecordService) updateRecordVersion(ctx context.Context, tx *sql.Tx, id int, updates map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error) {
 	if id <= 0 {
-		return entity.Record{}, ErrRecordIDInvalid
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	current, err := s.latestLiveRecordVersion(ctx, tx, id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := opts.checkNotBackdated(current); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	data := current.Data
+	applyUpdates(data, updates)
+
+	return s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{Data: data, Changes: updates}, opts)
+}
+
+func (s *DBRecordService) RevertRecord(ctx context.Context, id int, toVersion int, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+	if toVersion <= 0 {
+		return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
 	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return entity.Record{}, err
+		return entity.RecordVersion{}, err
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	var currentVersion int
-	var currentCreatedAtMS int64
-	var dataJSON string
-	err = tx.QueryRowContext(
+	current, err := s.latestLiveRecordVersion(ctx, tx, id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	row := tx.QueryRowContext(
 		ctx,
-		`SELECT version, created_at_ms, data_json FROM record_versions WHERE record_id = ? ORDER BY version DESC LIMIT 1`,
+		`SELECT `+recordVersionColumns+` FROM record_versions WHERE collection = ? AND record_id = ? AND version = ?`,
+		s.collection,
 		id,
-	).Scan(&currentVersion, &currentCreatedAtMS, &dataJSON)
+		toVersion,
+	)
+	target, err := scanRecordVersion(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return entity.Record{}, ErrRecordDoesNotExist
+			return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
 		}
-		return entity.Record{}, err
+		return entity.RecordVersion{}, err
+	}
+	if target.Deleted {
+		return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
 	}
 
-	var data map[string]string
-	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-		return entity.Record{}, err
+	recordVersion, err := s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{
+		Data:              target.Data,
+		Changes:           changesBetween(current.Data, target.Data),
+		RevertedToVersion: &toVersion,
+	}, opts)
+	if err != nil {
+		return entity.RecordVersion{}, err
 	}
-	if data == nil {
-		data = map[string]string{}
+	if err := tx.Commit(); err != nil {
+		return entity.RecordVersion{}, err
 	}
-	for key, value := range updates {
-		if value == nil {
-			delete(data, key)
-		} else {
-			data[key] = *value
-		}
+
+	return recordVersion, nil
+}
+
+func (s *DBRecordService) DeleteRecord(ctx context.Context, id int) error {
+	_, err := s.DeleteRecordVersion(ctx, id, WriteOptions{})
+	return err
+}
+
+func (s *DBRecordService) DeleteRecordVersion(ctx context.Context, id int, opts WriteOptions) (entity.RecordVersion, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.RecordVersion{}, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	newDataJSONBytes, err := json.Marshal(data)
+	recordVersion, err := s.deleteRecordVersion(ctx, tx, id, opts)
 	if err != nil {
-		return entity.Record{}, err
+		return entity.RecordVersion{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	return recordVersion, nil
+}
+
+// deleteRecordVersion is DeleteRecordVersion inside tx.
+func (s *DBRecordService) deleteRecordVersion(ctx context.Context, tx *sql.Tx, id int, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	current, err := s.latestLiveRecordVersion(ctx, tx, id)
+	if err != nil {
+		return entity.RecordVersion{}, err
 	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	return s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{
+		Data:    map[string]interface{}{},
+		Changes: changesBetween(current.Data, map[string]interface{}{}),
+		Deleted: true,
+	}, opts)
+}
+
+func (s *DBRecordService) RestoreRecord(ctx context.Context, id int, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
 
-	newCreatedAtMS := time.Now().UTC().UnixMilli()
-	if newCreatedAtMS <= currentCreatedAtMS {
-		newCreatedAtMS = currentCreatedAtMS + 1
+	current, err := s.latestRecordVersion(ctx, tx, id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if !current.Deleted {
+		return entity.RecordVersion{}, ErrRecordNotDeleted
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
 	}
 
-	if _, err := tx.ExecContext(
+	row := tx.QueryRowContext(
 		ctx,
-		`INSERT INTO record_versions (record_id, version, created_at_ms, data_json) VALUES (?, ?, ?, ?)`,
+		`SELECT `+recordVersionColumns+` FROM record_versions WHERE collection = ? AND record_id = ? AND deleted = 0 ORDER BY version DESC LIMIT 1`,
+		s.collection,
 		id,
-		currentVersion+1,
-		newCreatedAtMS,
-		string(newDataJSONBytes),
-	); err != nil {
-		return entity.Record{}, err
+	)
+	target, err := scanRecordVersion(row)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	recordVersion, err := s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{
+		Data:              target.Data,
+		Changes:           changesBetween(current.Data, target.Data),
+		RevertedToVersion: &target.Version,
+	}, opts)
+	if err != nil {
+		return entity.RecordVersion{}, err
 	}
 	if err := tx.Commit(); err != nil {
-		return entity.Record{}, err
+		return entity.RecordVersion{}, err
 	}
 
-	return entity.Record{ID: id, Data: data}, nil
+	return recordVersion, nil
+}
+
+// latestRecordVersion reads the latest version of a record inside tx,
+// which may be a tombstone.
+func (s *DBRecordService) latestRecordVersion(ctx context.Context, tx *sql.Tx, id int) (entity.RecordVersion, error) {
+	row := tx.QueryRowContext(
+		ctx,
+		`SELECT `+recordVersionColumns+` FROM record_versions WHERE collection = ? AND record_id = ? ORDER BY version DESC LIMIT 1`,
+		s.collection,
+		id,
+	)
+	recordVersion, err := scanRecordVersion(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.RecordVersion{}, ErrRecordDoesNotExist
+		}
+		return entity.RecordVersion{}, err
+	}
+	return recordVersion, nil
+}
+
+// latestLiveRecordVersion is latestRecordVersion, treating a deleted record as missing.
+func (s *DBRecordService) latestLiveRecordVersion(ctx context.Context, tx *sql.Tx, id int) (entity.RecordVersion, error) {
+	recordVersion, err := s.latestRecordVersion(ctx, tx, id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if recordVersion.Deleted {
+		return entity.RecordVersion{}, ErrRecordDoesNotExist
+	}
+	return recordVersion, nil
+}
+
+// appendRecordVersion writes next as the version following current. The
+// version number, timestamps and effective range are filled in here; the
+// caller supplies the data and change set. Unless next is a tombstone, its
+// data must satisfy the record's schema.
+func (s *DBRecordService) appendRecordVersion(ctx context.Context, tx *sql.Tx, current entity.RecordVersion, next entity.RecordVersion, opts WriteOptions) (entity.RecordVersion, error) {
+	if !next.Deleted {
+		if err := s.validateRecordData(ctx, tx, current.ID, next.Data); err != nil {
+			return entity.RecordVersion{}, err
+		}
+	}
+
+	next, err := nextVersion(current, next, opts)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := insertRecordVersion(ctx, tx, s.collection, next); err != nil {
+		return entity.RecordVersion{}, err
+	}
+	return next, nil
+}
+
+func insertRecordVersion(ctx context.Context, tx *sql.Tx, collection string, recordVersion entity.RecordVersion) error {
+	dataJSON, changesJSON, err := encodeVersionJSON(recordVersion)
+	if err != nil {
+		return err
+	}
+
+	var effectiveToMS sql.NullInt64
+	if recordVersion.EffectiveToMS != nil {
+		effectiveToMS = sql.NullInt64{Int64: *recordVersion.EffectiveToMS, Valid: true}
+	}
+	var revertedToVersion sql.NullInt64
+	if recordVersion.RevertedToVersion != nil {
+		revertedToVersion = sql.NullInt64{Int64: int64(*recordVersion.RevertedToVersion), Valid: true}
+	}
+
+	// Writes are serialized, so seq grows in commit order and the change
+	// feed never sees a gap fill in behind it.
+	var seq int64
+	var previousChainHash sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT seq, chain_hash FROM record_versions ORDER BY seq DESC LIMIT 1`).Scan(&seq, &previousChainHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	seq++
+
+	var previousRecordHash sql.NullString
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT record_hash FROM record_versions WHERE collection = ? AND record_id = ? AND version = ?`,
+		collection,
+		recordVersion.ID,
+		recordVersion.Version-1,
+	).Scan(&previousRecordHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	recordHash, err := hashedVersionOf(collection, seq, recordVersion, dataJSON, changesJSON).hashRecord(previousRecordHash.String)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO record_versions (
+			collection, record_id, version, created_at_ms, effective_from_ms, effective_to_ms,
+			data_json, changes_json, reverted_to_version, deleted, actor, reason, transaction_id,
+			seq, record_hash, chain_hash
+		 ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		collection,
+		recordVersion.ID,
+		recordVersion.Version,
+		recordVersion.CreatedAtMS,
+		recordVersion.EffectiveFromMS,
+		effectiveToMS,
+		dataJSON,
+		changesJSON,
+		revertedToVersion,
+		recordVersion.Deleted,
+		sql.NullString{String: recordVersion.Actor, Valid: recordVersion.Actor != ""},
+		sql.NullString{String: recordVersion.Reason, Valid: recordVersion.Reason != ""},
+		sql.NullInt64{Int64: int64(recordVersion.TransactionID), Valid: recordVersion.TransactionID != 0},
+		seq,
+		recordHash,
+		linkHash(previousChainHash.String, recordHash),
+	)
+	if err != nil {
+		return err
+	}
+	return enqueueWebhookDeliveries(ctx, tx, collection, recordVersion)
 }
@@ -2,8 +2,20 @@ package service
 
 import (
 	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/rainbowmga/timetravel/entity"
 )
@@ -38,3 +50,1035 @@ func TestDBRecordService_PersistsAcrossRestarts(t *testing.T) {
 		t.Fatalf("unexpected record: %+v", got)
 	}
 }
+
+func TestDBRecordService_GetRecordVersionAsOf(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	january := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	march := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	may := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+	created, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"hours": "9-5"}, WriteOptions{EffectiveFromMS: &january})
+	if err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+
+	// The March change is only reported later.
+	updated, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"hours": "24/7"}, WriteOptions{EffectiveFromMS: &march})
+	if err != nil {
+		t.Fatalf("UpdateRecordVersion: %v", err)
+	}
+	if updated.Version != 2 || updated.EffectiveFromMS != march {
+		t.Fatalf("unexpected version: %+v", updated)
+	}
+
+	// As we know it now, May was already 24/7.
+	got, err := svc.GetRecordVersionAsOf(ctx, 1, may, updated.CreatedAtMS)
+	if err != nil {
+		t.Fatalf("GetRecordVersionAsOf: %v", err)
+	}
+	if got.Version != 2 || got.Data["hours"] != "24/7" {
+		t.Fatalf("unexpected version: %+v", got)
+	}
+
+	// Before the change was reported, we believed May was 9-5.
+	got, err = svc.GetRecordVersionAsOf(ctx, 1, may, created.CreatedAtMS)
+	if err != nil {
+		t.Fatalf("GetRecordVersionAsOf: %v", err)
+	}
+	if got.Version != 1 || got.Data["hours"] != "9-5" {
+		t.Fatalf("unexpected version: %+v", got)
+	}
+
+	// February predates the change no matter when we ask.
+	got, err = svc.GetRecordVersionAsOf(ctx, 1, march-1, updated.CreatedAtMS)
+	if err != nil {
+		t.Fatalf("GetRecordVersionAsOf: %v", err)
+	}
+	if got.Version != 1 {
+		t.Fatalf("unexpected version: %+v", got)
+	}
+
+	if _, err := svc.GetRecordVersionAsOf(ctx, 1, january-1, updated.CreatedAtMS); err != ErrRecordDoesNotExist {
+		t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+	}
+
+	if _, err := svc.UpdateRecordVersion(ctx, 1, nil, WriteOptions{EffectiveFromMS: &march, EffectiveToMS: &january}); err != ErrEffectiveRangeInvalid {
+		t.Fatalf("expected ErrEffectiveRangeInvalid, got %v", err)
+	}
+}
+
+func newTestDBRecordService(t *testing.T) *DBRecordService {
+	t.Helper()
+
+	svc, err := NewDBRecordService(filepath.Join(t.TempDir(), "timetravel.db"))
+	if err != nil {
+		t.Fatalf("NewDBRecordService: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+	return svc
+}
+
+func TestDBRecordService_UpdateRecordVersion_ExpectedVersion(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	stale := 0
+	if _, err := svc.CreateRecordVersion(ctx, 1, nil, WriteOptions{ExpectedVersion: &stale}); err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+
+	current := 1
+	if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"key": "a"}, WriteOptions{ExpectedVersion: &current}); err != nil {
+		t.Fatalf("UpdateRecordVersion: %v", err)
+	}
+
+	// A second writer that also read version 1 must not overwrite the first.
+	if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"key": "a"}, WriteOptions{ExpectedVersion: &current}); err != ErrVersionConflict {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+	if _, err := svc.CreateRecordVersion(ctx, 2, nil, WriteOptions{ExpectedVersion: &current}); err != ErrVersionConflict {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+
+	latest, err := svc.GetLatestRecordVersion(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetLatestRecordVersion: %v", err)
+	}
+	if latest.Version != 2 {
+		t.Fatalf("unexpected version: %+v", latest)
+	}
+}
+
+func TestDBRecordService_RevertRecord(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	if err := svc.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"limit": "1M", "state": "CA"}}); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	limit, office := "5M", "SF"
+	if _, err := svc.UpdateRecord(ctx, 1, map[string]*string{"limit": &limit, "state": nil, "office": &office}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+
+	reverted, err := svc.RevertRecord(ctx, 1, 1, WriteOptions{})
+	if err != nil {
+		t.Fatalf("RevertRecord: %v", err)
+	}
+	if reverted.Version != 3 || reverted.RevertedToVersion == nil || *reverted.RevertedToVersion != 1 {
+		t.Fatalf("unexpected version: %+v", reverted)
+	}
+	if len(reverted.Data) != 2 || reverted.Data["limit"] != "1M" || reverted.Data["state"] != "CA" {
+		t.Fatalf("unexpected data: %+v", reverted.Data)
+	}
+	if office, ok := reverted.Changes["office"]; !ok || office != nil || reverted.Changes["limit"] != "1M" {
+		t.Fatalf("unexpected changes: %+v", reverted.Changes)
+	}
+
+	versions, err := svc.ListRecordVersions(ctx, 1, ListVersionsOptions{})
+	if err != nil {
+		t.Fatalf("ListRecordVersions: %v", err)
+	}
+	if len(versions.Versions) != 3 || versions.Versions[1].Data["limit"] != "5M" {
+		t.Fatalf("expected history to be preserved: %+v", versions)
+	}
+
+	if _, err := svc.RevertRecord(ctx, 1, 9, WriteOptions{}); err != ErrRecordVersionDoesNotExist {
+		t.Fatalf("expected ErrRecordVersionDoesNotExist, got %v", err)
+	}
+}
+
+func TestDBRecordService_ListRecordVersions_Pagination(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	if err := svc.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"n": "1"}}); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	for _, n := range []string{"2", "3", "4", "5"} {
+		n := n
+		if _, err := svc.UpdateRecord(ctx, 1, map[string]*string{"n": &n}); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+	}
+
+	var seen []int
+	opts := ListVersionsOptions{Limit: 2, Descending: true, OmitData: true}
+	for {
+		page, err := svc.ListRecordVersions(ctx, 1, opts)
+		if err != nil {
+			t.Fatalf("ListRecordVersions: %v", err)
+		}
+		for _, info := range page.Versions {
+			if info.Data != nil {
+				t.Fatalf("expected data to be omitted: %+v", info)
+			}
+			seen = append(seen, info.Version)
+		}
+		if page.NextCursor == nil {
+			break
+		}
+		opts.AfterVersion = *page.NextCursor
+	}
+	if len(seen) != 5 || seen[0] != 5 || seen[4] != 1 {
+		t.Fatalf("unexpected versions: %v", seen)
+	}
+
+	v3, err := svc.GetRecordVersion(ctx, 1, 3)
+	if err != nil {
+		t.Fatalf("GetRecordVersion: %v", err)
+	}
+	page, err := svc.ListRecordVersions(ctx, 1, ListVersionsOptions{FromMS: &v3.CreatedAtMS})
+	if err != nil {
+		t.Fatalf("ListRecordVersions: %v", err)
+	}
+	if len(page.Versions) != 3 || page.Versions[0].Version != 3 || page.Versions[0].Data["n"] != "3" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+
+	page, err = svc.ListRecordVersions(ctx, 1, ListVersionsOptions{AfterVersion: 5})
+	if err != nil || len(page.Versions) != 0 {
+		t.Fatalf("expected an empty page, got %+v, %v", page, err)
+	}
+	if _, err := svc.ListRecordVersions(ctx, 2, ListVersionsOptions{}); err != ErrRecordDoesNotExist {
+		t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+	}
+}
+
+func TestDBRecordService_FieldHistory(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	if err := svc.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"employee_count": "10"}}); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	ten, twelve, other := "10", "12", "x"
+	for _, updates := range []map[string]*string{
+		{"other": &other},
+		{"employee_count": &twelve},
+		{"employee_count": nil},
+		{"employee_count": &ten},
+	} {
+		if _, err := svc.UpdateRecord(ctx, 1, updates); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+	}
+
+	history, err := svc.FieldHistory(ctx, 1, "employee_count")
+	if err != nil {
+		t.Fatalf("FieldHistory: %v", err)
+	}
+	var got []string
+	for _, change := range history.Changes {
+		value := interface{}("<removed>")
+		if change.Value != nil {
+			value = change.Value
+		}
+		got = append(got, fmt.Sprintf("v%d=%v", change.Version, value))
+	}
+	want := []string{"v1=10", "v3=12", "v4=<removed>", "v5=10"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if !history.Changes[2].Removed {
+		t.Fatalf("expected removal to be flagged: %+v", history.Changes[2])
+	}
+
+	if _, err := svc.FieldHistory(ctx, 2, "employee_count"); err != ErrRecordDoesNotExist {
+		t.Fatalf("expected ErrRecordDoesNotExist, got %v", err)
+	}
+}
+
+func TestDBRecordService_SnapshotAt_DoesNotBlockWrites(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	svc := newTestDBRecordService(t)
+
+	if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"state": "CA"}, WriteOptions{createdAtMS: 1000}); err != nil {
+		t.Fatalf("create 1: %v", err)
+	}
+	if _, err := svc.CreateRecordVersion(ctx, 2, map[string]interface{}{"state": "NY"}, WriteOptions{createdAtMS: 2000}); err != nil {
+		t.Fatalf("create 2: %v", err)
+	}
+
+	// Writes made while the snapshot streams go through, and don't show up
+	// in it even when they are backdated to before its time.
+	var states []string
+	err := svc.SnapshotAt(ctx, 3000, func(recordVersion entity.RecordVersion) error {
+		if recordVersion.ID == 1 {
+			if _, err := svc.UpdateRecordVersion(ctx, 2, map[string]interface{}{"state": "TX"}, WriteOptions{createdAtMS: 2500}); err != nil {
+				return err
+			}
+			if _, err := svc.CreateRecordVersion(ctx, 3, map[string]interface{}{"state": "WA"}, WriteOptions{createdAtMS: 2500}); err != nil {
+				return err
+			}
+		}
+		states = append(states, recordVersion.Data["state"].(string))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SnapshotAt: %v", err)
+	}
+	if strings.Join(states, ",") != "CA,NY" {
+		t.Fatalf("unexpected snapshot: %v", states)
+	}
+
+	latest, err := svc.GetRecord(ctx, 2)
+	if err != nil || latest.Data["state"] != "TX" {
+		t.Fatalf("expected the write to land: %+v %v", latest, err)
+	}
+}
+
+func TestDBRecordService_Collections(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "timetravel.db")
+
+	// A database from before collections existed, keyed by record id alone.
+	legacy, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := legacy.Exec(`
+		CREATE TABLE record_versions (
+			record_id     INTEGER NOT NULL,
+			version       INTEGER NOT NULL,
+			data_json     TEXT NOT NULL,
+			created_at_ms INTEGER NOT NULL,
+			PRIMARY KEY (record_id, version)
+		);
+		INSERT INTO record_versions (record_id, version, data_json, created_at_ms) VALUES (1, 1, '{"name":"Acme"}', 1700000000000);
+	`); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	_ = legacy.Close()
+
+	svc, err := NewDBRecordService(dbPath)
+	if err != nil {
+		t.Fatalf("NewDBRecordService: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	got, err := svc.GetRecord(ctx, 1)
+	if err != nil || got.Data["name"] != "Acme" {
+		t.Fatalf("legacy record: %+v, %v", got, err)
+	}
+
+	if _, err := svc.Collection(ctx, "vehicles"); err != ErrCollectionDoesNotExist {
+		t.Fatalf("expected ErrCollectionDoesNotExist, got %v", err)
+	}
+	if _, err := svc.PutCollection(ctx, entity.Collection{Name: "webhooks"}); err != ErrCollectionNameReserved {
+		t.Fatalf("expected ErrCollectionNameReserved, got %v", err)
+	}
+	if _, err := svc.PutCollection(ctx, entity.Collection{Name: "vehicles"}); err != nil {
+		t.Fatalf("PutCollection: %v", err)
+	}
+	vehicles, err := svc.Collection(ctx, "vehicles")
+	if err != nil {
+		t.Fatalf("Collection: %v", err)
+	}
+
+	// Ids and versions are per collection.
+	created, err := vehicles.CreateRecordVersion(ctx, 1, map[string]interface{}{"make": "Volvo"}, WriteOptions{})
+	if err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+	if created.Version != 1 {
+		t.Fatalf("expected version 1, got %d", created.Version)
+	}
+	got, err = svc.GetRecord(ctx, 1)
+	if err != nil || got.Data["name"] != "Acme" || got.Data["make"] != "" {
+		t.Fatalf("default collection changed: %+v, %v", got, err)
+	}
+	list, err := vehicles.ListRecords(ctx, ListRecordsOptions{})
+	if err != nil || len(list.Records) != 1 || list.Records[0].Data["make"] != "Volvo" {
+		t.Fatalf("unexpected vehicles: %+v, %v", list, err)
+	}
+
+	// The legacy version was numbered into the change feed ahead of new writes.
+	changes, err := svc.ListChanges(ctx, ChangesOptions{})
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("unexpected changes: %+v, %v", changes, err)
+	}
+	if changes[0].Seq != 1 || changes[0].Collection != DefaultCollection || changes[1].Seq != 2 || changes[1].Collection != "vehicles" {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+	changes, err = svc.ListChanges(ctx, ChangesOptions{AfterSeq: 1, Collection: DefaultCollection})
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("unexpected changes after 1: %+v, %v", changes, err)
+	}
+	// So were its hashes.
+	verification, err := svc.VerifyAll(ctx)
+	if err != nil || !verification.Valid || verification.Checked != 2 {
+		t.Fatalf("unexpected verification: %+v, %v", verification, err)
+	}
+}
+
+func TestDBRecordService_RegisterSchema_ExternalRef(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	external := filepath.Join(t.TempDir(), "external.json")
+	if err := ioutil.WriteFile(external, []byte(`{"type": "object"}`), 0o600); err != nil {
+		t.Fatalf("write external schema: %v", err)
+	}
+
+	for _, ref := range []string{"file://" + external, "http://127.0.0.1:1/schema.json"} {
+		schema := json.RawMessage(`{"$ref": "` + ref + `"}`)
+		if _, err := svc.RegisterSchema(ctx, "policy", schema); err != ErrSchemaInvalid {
+			t.Fatalf("%s: expected ErrSchemaInvalid, got %v", ref, err)
+		}
+	}
+
+	// References within the schema itself still resolve.
+	schema := json.RawMessage(`{"$defs": {"name": {"type": "string"}}, "properties": {"name": {"$ref": "#/$defs/name"}}}`)
+	if _, err := svc.RegisterSchema(ctx, "policy", schema); err != nil {
+		t.Fatalf("RegisterSchema: %v", err)
+	}
+}
+
+func TestDBRecordService_ExpandRecord(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	if _, err := svc.PutCollection(ctx, entity.Collection{Name: "drivers"}); err != nil {
+		t.Fatalf("PutCollection: %v", err)
+	}
+	drivers := svc.inCollection("drivers")
+
+	if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"policy": "P-1"}, WriteOptions{createdAtMS: 1000}); err != nil {
+		t.Fatalf("CreateRecordVersion policy: %v", err)
+	}
+	driver := entity.Link{Type: "driver", Collection: "drivers", ID: 7}
+	if _, err := svc.LinkRecord(ctx, 1, driver, WriteOptions{createdAtMS: 1000}); err != ErrLinkTargetDoesNotExist {
+		t.Fatalf("expected ErrLinkTargetDoesNotExist, got %v", err)
+	}
+	if _, err := drivers.CreateRecordVersion(ctx, 7, map[string]interface{}{"name": "Ann"}, WriteOptions{createdAtMS: 1000}); err != nil {
+		t.Fatalf("CreateRecordVersion driver: %v", err)
+	}
+	linked, err := svc.LinkRecord(ctx, 1, driver, WriteOptions{createdAtMS: 1000})
+	if err != nil {
+		t.Fatalf("LinkRecord: %v", err)
+	}
+
+	// Later, the driver is renamed and then taken off the policy.
+	if _, err := drivers.UpdateRecordVersion(ctx, 7, map[string]interface{}{"name": "Ann B"}, WriteOptions{createdAtMS: 2000}); err != nil {
+		t.Fatalf("UpdateRecordVersion driver: %v", err)
+	}
+	if _, err := svc.UnlinkRecord(ctx, 1, driver, WriteOptions{createdAtMS: 3000}); err != nil {
+		t.Fatalf("UnlinkRecord: %v", err)
+	}
+
+	then, err := svc.ExpandRecord(ctx, 1, linked.CreatedAtMS)
+	if err != nil {
+		t.Fatalf("ExpandRecord then: %v", err)
+	}
+	if len(then.Links) != 1 || then.Links[0].Record == nil || then.Links[0].Record.Data["name"] != "Ann" {
+		t.Fatalf("unexpected expansion then: %+v", then)
+	}
+
+	now, err := svc.ExpandRecord(ctx, 1, 3000)
+	if err != nil {
+		t.Fatalf("ExpandRecord now: %v", err)
+	}
+	if now.Record.Data["policy"] != "P-1" || len(now.Links) != 0 {
+		t.Fatalf("unexpected expansion now: %+v", now)
+	}
+}
+
+func TestDBRecordService_ImportRecords(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	if _, err := svc.RegisterSchema(ctx, "policy", json.RawMessage(`{"type":"object","required":["name"]}`)); err != nil {
+		t.Fatalf("RegisterSchema: %v", err)
+	}
+	if _, err := svc.PutCollection(ctx, entity.Collection{Name: DefaultCollection, SchemaName: "policy"}); err != nil {
+		t.Fatalf("PutCollection: %v", err)
+	}
+
+	ndjson := `{"id":1,"data":{"name":"Acme","employees":12}}
+{"id":2,"data":{"employees":3}}
+not json
+
+{"id":3,"data":{"name":"Globex"}}
+`
+	// A dry run reports the same errors but writes nothing.
+	report, err := svc.ImportRecords(ctx, strings.NewReader(ndjson), ImportOptions{Format: ImportFormatNDJSON, BatchSize: 2, DryRun: true})
+	if err != nil {
+		t.Fatalf("ImportRecords dry run: %v", err)
+	}
+	if report.Lines != 4 || report.Imported != 2 || report.Failed != 2 {
+		t.Fatalf("unexpected dry run report: %+v", report)
+	}
+	if _, err := svc.GetLatestRecordVersion(ctx, 1); err != ErrRecordDoesNotExist {
+		t.Fatalf("dry run wrote record 1: %v", err)
+	}
+
+	report, err = svc.ImportRecords(ctx, strings.NewReader(ndjson), ImportOptions{Format: ImportFormatNDJSON, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("ImportRecords: %v", err)
+	}
+	if report.Imported != 2 || len(report.Errors) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.Errors[0].Line != 2 || report.Errors[0].ID != 2 || len(report.Errors[0].Fields) == 0 {
+		t.Fatalf("unexpected schema error: %+v", report.Errors[0])
+	}
+	if report.Errors[1].Line != 3 {
+		t.Fatalf("unexpected parse error: %+v", report.Errors[1])
+	}
+
+	// A CSV import replaces the data of records that already exist.
+	csv := "id,name,city\n1,Acme Corp,Austin\n4,Initech,\n"
+	report, err = svc.ImportRecords(ctx, strings.NewReader(csv), ImportOptions{Format: ImportFormatCSV})
+	if err != nil {
+		t.Fatalf("ImportRecords csv: %v", err)
+	}
+	if report.Imported != 2 || report.Failed != 0 {
+		t.Fatalf("unexpected csv report: %+v", report)
+	}
+	acme, err := svc.GetLatestRecordVersion(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetLatestRecordVersion: %v", err)
+	}
+	want := map[string]interface{}{"name": "Acme Corp", "city": "Austin"}
+	if acme.Version != 2 || !reflect.DeepEqual(acme.Data, want) {
+		t.Fatalf("unexpected record 1: %+v", acme)
+	}
+
+	// A rejected line is left out of its batch; the rest commit together.
+	ndjson = `{"id":5,"data":{"name":"Hooli"}}
+{"id":6,"data":{"employees":40}}
+{"id":7,"data":{"name":"Pied Piper"}}
+`
+	report, err = svc.ImportRecords(ctx, strings.NewReader(ndjson), ImportOptions{Format: ImportFormatNDJSON, WriteOptions: WriteOptions{Actor: "loader"}})
+	if err != nil {
+		t.Fatalf("ImportRecords batch: %v", err)
+	}
+	if report.Imported != 2 || len(report.Errors) != 1 || report.Errors[0].ID != 6 {
+		t.Fatalf("unexpected batch report: %+v", report)
+	}
+	hooli, err := svc.GetLatestRecordVersion(ctx, 5)
+	if err != nil {
+		t.Fatalf("GetLatestRecordVersion 5: %v", err)
+	}
+	piedPiper, err := svc.GetLatestRecordVersion(ctx, 7)
+	if err != nil {
+		t.Fatalf("GetLatestRecordVersion 7: %v", err)
+	}
+	if hooli.TransactionID != 0 || hooli.CreatedAtMS != piedPiper.CreatedAtMS || hooli.Actor != "loader" {
+		t.Fatalf("unexpected batch versions: %+v %+v", hooli, piedPiper)
+	}
+	if _, err := svc.GetLatestRecordVersion(ctx, 6); err != ErrRecordDoesNotExist {
+		t.Fatalf("rejected record 6 was written: %v", err)
+	}
+}
+
+func TestDBRecordService_ExportRecords(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"name": "Acme"}, WriteOptions{createdAtMS: 1000}); err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+	if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"employees": json.Number("12")}, WriteOptions{Actor: "alice", createdAtMS: 2000}); err != nil {
+		t.Fatalf("UpdateRecordVersion: %v", err)
+	}
+	if _, err := svc.CreateRecordVersion(ctx, 2, map[string]interface{}{"name": "Globex, Inc."}, WriteOptions{createdAtMS: 3000}); err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+
+	var out strings.Builder
+	if err := svc.ExportRecords(ctx, &out, ExportOptions{Format: ExportFormatNDJSON}); err != nil {
+		t.Fatalf("ExportRecords: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 versions, got %q", out.String())
+	}
+	var second entity.RecordVersion
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("decoding %q: %v", lines[1], err)
+	}
+	if second.ID != 1 || second.Version != 2 || second.Actor != "alice" || second.CreatedAtMS != 2000 {
+		t.Fatalf("unexpected second version: %+v", second)
+	}
+
+	out.Reset()
+	toMS := int64(3000)
+	if err := svc.ExportRecords(ctx, &out, ExportOptions{Format: ExportFormatCSV, ToID: 1, ToMS: &toMS}); err != nil {
+		t.Fatalf("ExportRecords csv: %v", err)
+	}
+	want := "id,version,created_at_ms,effective_from_ms,effective_to_ms,deleted,reverted_to_version,actor,reason,transaction_id,changes,data.employees,data.name\n" +
+		`1,1,1000,1000,,false,,,,,"{""name"":""Acme""}",,Acme` + "\n" +
+		`1,2,2000,2000,,false,,alice,,,"{""employees"":12}",12,Acme` + "\n"
+	if out.String() != want {
+		t.Fatalf("unexpected csv:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	if err := svc.ExportRecords(ctx, &out, ExportOptions{Format: "xml"}); err != ErrExportFormatInvalid {
+		t.Fatalf("expected ErrExportFormatInvalid, got %v", err)
+	}
+
+	// Writes made while an export streams go through but stay out of it.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out.Reset()
+	wrote := false
+	writer := writerFunc(func(p []byte) (int, error) {
+		if !wrote {
+			wrote = true
+			if _, err := svc.UpdateRecordVersion(timeoutCtx, 2, map[string]interface{}{"city": "Austin"}, WriteOptions{createdAtMS: 3500}); err != nil {
+				return 0, err
+			}
+		}
+		return out.Write(p)
+	})
+	if err := svc.ExportRecords(timeoutCtx, writer, ExportOptions{Format: ExportFormatNDJSON}); err != nil {
+		t.Fatalf("ExportRecords while writing: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 3 {
+		t.Fatalf("expected the 3 versions from before the write, got %q", out.String())
+	}
+}
+
+// writerFunc adapts a function to io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestDBRecordService_Webhooks(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	for _, url := range []string{
+		"http://localhost:8000/hook",
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.8/hook",
+		"http://0.0.0.0/hook",
+	} {
+		if _, err := svc.CreateWebhook(ctx, entity.Webhook{URL: url}); err != ErrWebhookHostNotAllowed {
+			t.Fatalf("%s: expected ErrWebhookHostNotAllowed, got %v", url, err)
+		}
+	}
+	// The receivers below listen on loopback.
+	svc.allowPrivateWebhooks = true
+
+	type received struct {
+		seq       string
+		signature string
+		body      []byte
+	}
+	var mu sync.Mutex
+	var deliveries []received
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		deliveries = append(deliveries, received{r.Header.Get(WebhookSeqHeader), r.Header.Get(WebhookSignatureHeader), body})
+		mu.Unlock()
+	}))
+	t.Cleanup(receiver.Close)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+
+	// Versions written before a webhook exists are not sent to it.
+	if _, err := svc.CreateRecordVersion(ctx, 5, map[string]interface{}{"name": "Initech"}, WriteOptions{}); err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+	webhook, err := svc.CreateWebhook(ctx, entity.Webhook{URL: receiver.URL, Secret: "s3cret"})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	broken, err := svc.CreateWebhook(ctx, entity.Webhook{URL: failing.URL, Collection: DefaultCollection})
+	if err != nil || broken.Secret == "" {
+		t.Fatalf("CreateWebhook: %+v, %v", broken, err)
+	}
+	if _, err := svc.CreateWebhook(ctx, entity.Webhook{URL: "ftp://example.com"}); err != ErrWebhookURLInvalid {
+		t.Fatalf("expected ErrWebhookURLInvalid, got %v", err)
+	}
+
+	if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"name": "Acme"}, WriteOptions{}); err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+	if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"name": "Acme Corp"}, WriteOptions{}); err != nil {
+		t.Fatalf("UpdateRecordVersion: %v", err)
+	}
+	// A transaction that rolls back queues nothing.
+	if _, err := svc.ApplyTransaction(ctx, []entity.Operation{
+		{Op: entity.OperationCreate, ID: 2, Data: map[string]interface{}{"name": "Globex"}},
+		{Op: entity.OperationCreate, ID: 1},
+	}, WriteOptions{}); err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+
+	dispatcher := NewWebhookDispatcher(svc, WebhookDispatcherOptions{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	var deadLetters []entity.WebhookDelivery
+	for i := 0; i < 100 && len(deadLetters) < 2; i++ {
+		if _, err := dispatcher.DispatchDue(ctx); err != nil {
+			t.Fatalf("DispatchDue: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		if deadLetters, err = svc.ListDeadLetters(ctx, broken.ID); err != nil {
+			t.Fatalf("ListDeadLetters: %v", err)
+		}
+	}
+
+	if len(deadLetters) != 2 || deadLetters[0].Seq != 2 || deadLetters[0].Attempts != 2 || !strings.Contains(deadLetters[0].LastError, "500") {
+		t.Fatalf("unexpected dead letters: %+v", deadLetters)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deliveries) != 2 || deliveries[0].seq != "2" || deliveries[1].seq != "3" {
+		t.Fatalf("unexpected deliveries: %+v", deliveries)
+	}
+	for _, delivery := range deliveries {
+		if delivery.signature != WebhookSignature(webhook.Secret, delivery.body) {
+			t.Fatalf("bad signature %q for %s", delivery.signature, delivery.body)
+		}
+	}
+	var change entity.Change
+	if err := json.Unmarshal(deliveries[1].body, &change); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if change.Seq != 3 || change.ID != 1 || change.Version != 2 || change.Data["name"] != "Acme Corp" {
+		t.Fatalf("unexpected payload: %+v", change)
+	}
+}
+
+func TestWebhookDispatcher_SlowWebhooks(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	// Each webhook answers only once both requests are in flight, which a
+	// dispatcher sending one request at a time would never get to.
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	bothArrived := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(bothArrived)
+	}()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		select {
+		case <-bothArrived:
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	t.Cleanup(slow.Close)
+
+	svc.allowPrivateWebhooks = true
+	for i := 0; i < 2; i++ {
+		if _, err := svc.CreateWebhook(ctx, entity.Webhook{URL: slow.URL}); err != nil {
+			t.Fatalf("CreateWebhook: %v", err)
+		}
+	}
+	if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"name": "Acme"}, WriteOptions{}); err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+
+	dispatcher := NewWebhookDispatcher(svc, WebhookDispatcherOptions{MaxAttempts: 1, Concurrency: 2})
+	if attempted, err := dispatcher.DispatchDue(ctx); err != nil || attempted != 2 {
+		t.Fatalf("DispatchDue: %d, %v", attempted, err)
+	}
+	var delivered int
+	if err := svc.db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries WHERE status = ?`, entity.WebhookDeliveryDelivered).Scan(&delivered); err != nil || delivered != 2 {
+		t.Fatalf("expected 2 delivered, got %d, %v", delivered, err)
+	}
+
+	// Delivered deliveries are kept for DeliveredRetention.
+	if pruned, err := dispatcher.PruneDelivered(ctx); err != nil || pruned != 0 {
+		t.Fatalf("PruneDelivered: %d, %v", pruned, err)
+	}
+	if _, err := svc.db.Exec(`UPDATE webhook_deliveries SET last_attempt_at_ms = last_attempt_at_ms - ?`, (8 * 24 * time.Hour).Milliseconds()); err != nil {
+		t.Fatalf("aging deliveries: %v", err)
+	}
+	if pruned, err := dispatcher.PruneDelivered(ctx); err != nil || pruned != 2 {
+		t.Fatalf("PruneDelivered: %d, %v", pruned, err)
+	}
+}
+
+func TestWebhookDispatcher_BlocksPrivateAddresses(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	var requests int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	t.Cleanup(receiver.Close)
+
+	// A host can resolve to a public address at registration and to a
+	// private one by the time it is sent to.
+	svc.allowPrivateWebhooks = true
+	webhook, err := svc.CreateWebhook(ctx, entity.Webhook{URL: receiver.URL})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"name": "Acme"}, WriteOptions{}); err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+	svc.allowPrivateWebhooks = false
+
+	dispatcher := NewWebhookDispatcher(svc, WebhookDispatcherOptions{MaxAttempts: 1})
+	if attempted, err := dispatcher.DispatchDue(ctx); err != nil || attempted != 1 {
+		t.Fatalf("DispatchDue: %d, %v", attempted, err)
+	}
+	deadLetters, err := svc.ListDeadLetters(ctx, webhook.ID)
+	if err != nil {
+		t.Fatalf("ListDeadLetters: %v", err)
+	}
+	if len(deadLetters) != 1 || !strings.Contains(deadLetters[0].LastError, ErrWebhookHostNotAllowed.Error()) {
+		t.Fatalf("unexpected dead letters: %+v", deadLetters)
+	}
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Fatal("the webhook was sent to a loopback address")
+	}
+}
+
+func TestDBRecordService_Verify(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	for _, write := range []struct {
+		id   int
+		data map[string]interface{}
+	}{
+		{1, map[string]interface{}{"name": "Acme"}},
+		{2, map[string]interface{}{"name": "Globex"}},
+		{1, map[string]interface{}{"employees": json.Number("12")}},
+		{1, map[string]interface{}{"employees": nil}},
+	} {
+		var err error
+		if _, getErr := svc.GetLatestRecordVersion(ctx, write.id); getErr == ErrRecordDoesNotExist {
+			_, err = svc.CreateRecordVersion(ctx, write.id, write.data, WriteOptions{})
+		} else {
+			_, err = svc.UpdateRecordVersion(ctx, write.id, write.data, WriteOptions{Actor: "alice"})
+		}
+		if err != nil {
+			t.Fatalf("write %d: %v", write.id, err)
+		}
+	}
+
+	verification, err := svc.VerifyRecord(ctx, 1)
+	if err != nil || !verification.Valid || verification.Checked != 3 {
+		t.Fatalf("unexpected verification: %+v, %v", verification, err)
+	}
+	verification, err = svc.VerifyAll(ctx)
+	if err != nil || !verification.Valid || verification.Checked != 4 {
+		t.Fatalf("unexpected verification: %+v, %v", verification, err)
+	}
+
+	// Editing a version in place breaks its own hash.
+	if _, err := svc.db.Exec(`UPDATE record_versions SET data_json = '{"name":"Initech"}' WHERE record_id = 1 AND version = 2`); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	verification, err = svc.VerifyRecord(ctx, 1)
+	if err != nil || verification.Valid || verification.Checked != 1 {
+		t.Fatalf("unexpected verification: %+v, %v", verification, err)
+	}
+	if broken := verification.Broken; broken.ID != 1 || broken.Version != 2 || broken.Seq != 3 || broken.Chain != entity.ChainRecord {
+		t.Fatalf("unexpected broken link: %+v", broken)
+	}
+	verification, err = svc.VerifyAll(ctx)
+	if err != nil || verification.Valid || verification.Broken.Seq != 3 {
+		t.Fatalf("unexpected verification: %+v, %v", verification, err)
+	}
+	if verification, err := svc.VerifyRecord(ctx, 2); err != nil || !verification.Valid {
+		t.Fatalf("record 2 should still verify: %+v, %v", verification, err)
+	}
+
+	// Removing a version breaks the link to it.
+	if _, err := svc.db.Exec(`DELETE FROM record_versions WHERE record_id = 1 AND version = 2`); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	verification, err = svc.VerifyRecord(ctx, 1)
+	if err != nil || verification.Valid || verification.Broken.Version != 3 || verification.Broken.Reason != "version 2 is missing" {
+		t.Fatalf("unexpected verification: %+v, %v", verification, err)
+	}
+}
+
+func TestDBRecordService_Verify_NulledHashes(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "timetravel.db")
+
+	svc, err := NewDBRecordService(dbPath)
+	if err != nil {
+		t.Fatalf("NewDBRecordService: %v", err)
+	}
+	if _, err := svc.CreateRecordVersion(ctx, 1, map[string]interface{}{"name": "Acme"}, WriteOptions{}); err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+	if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"name": "Acme Corp"}, WriteOptions{}); err != nil {
+		t.Fatalf("UpdateRecordVersion: %v", err)
+	}
+
+	// Editing a version and clearing its hashes is not undone by a restart,
+	// which would otherwise hash the edit as if it were history.
+	if _, err := svc.db.Exec(`UPDATE record_versions SET data_json = '{"name":"Initech"}', record_hash = NULL, chain_hash = NULL WHERE record_id = 1 AND version = 2`); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	if err := svc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	svc, err = NewDBRecordService(dbPath)
+	if err != nil {
+		t.Fatalf("NewDBRecordService (reopen): %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	verification, err := svc.VerifyAll(ctx)
+	if err != nil || verification.Valid || verification.Checked != 1 {
+		t.Fatalf("unexpected verification: %+v, %v", verification, err)
+	}
+	if broken := verification.Broken; broken.Version != 2 || broken.Reason != "version has no hash" {
+		t.Fatalf("unexpected broken link: %+v", broken)
+	}
+}
+
+func TestDBRecordService_Checkpoints(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestDBRecordService(t)
+
+	if _, err := svc.CreateCheckpoint(ctx); err != ErrCheckpointKeyMissing {
+		t.Fatalf("expected ErrCheckpointKeyMissing, got %v", err)
+	}
+	svc.SetCheckpointKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+
+	if _, err := svc.PutCollection(ctx, entity.Collection{Name: "vehicles"}); err != nil {
+		t.Fatalf("PutCollection: %v", err)
+	}
+	vehicles, err := svc.Collection(ctx, "vehicles")
+	if err != nil {
+		t.Fatalf("Collection: %v", err)
+	}
+	for id := 1; id <= 3; id++ {
+		if _, err := svc.CreateRecordVersion(ctx, id, map[string]interface{}{"name": fmt.Sprint(id)}, WriteOptions{}); err != nil {
+			t.Fatalf("CreateRecordVersion: %v", err)
+		}
+	}
+	if _, err := svc.UpdateRecordVersion(ctx, 2, map[string]interface{}{"name": "two"}, WriteOptions{}); err != nil {
+		t.Fatalf("UpdateRecordVersion: %v", err)
+	}
+	if _, err := vehicles.CreateRecordVersion(ctx, 1, map[string]interface{}{"make": "Volvo"}, WriteOptions{}); err != nil {
+		t.Fatalf("CreateRecordVersion: %v", err)
+	}
+
+	checkpoint, err := svc.CreateCheckpoint(ctx)
+	if err != nil {
+		t.Fatalf("CreateCheckpoint: %v", err)
+	}
+	if checkpoint.TreeSize != 5 || !VerifyCheckpoint(checkpoint) {
+		t.Fatalf("unexpected checkpoint: %+v", checkpoint)
+	}
+
+	for _, version := range []struct {
+		records VersionedRecordService
+		id      int
+		version int
+	}{{svc, 1, 1}, {svc, 2, 1}, {svc, 3, 1}, {svc, 2, 2}, {vehicles, 1, 1}} {
+		proof, err := version.records.(CheckpointService).ProveVersion(ctx, version.id, version.version, 0)
+		if err != nil {
+			t.Fatalf("ProveVersion(%d, %d): %v", version.id, version.version, err)
+		}
+		if !VerifyInclusionProof(proof) {
+			t.Fatalf("proof does not verify: %+v", proof)
+		}
+	}
+
+	// A version written after the latest checkpoint is not in it yet.
+	if _, err := svc.UpdateRecordVersion(ctx, 1, map[string]interface{}{"name": "one"}, WriteOptions{}); err != nil {
+		t.Fatalf("UpdateRecordVersion: %v", err)
+	}
+	if _, err := svc.ProveVersion(ctx, 1, 2, 0); err != ErrVersionNotCheckpointed {
+		t.Fatalf("expected ErrVersionNotCheckpointed, got %v", err)
+	}
+	if _, err := svc.CreateCheckpoint(ctx); err != nil {
+		t.Fatalf("CreateCheckpoint: %v", err)
+	}
+	proof, err := svc.ProveVersion(ctx, 1, 2, 0)
+	if err != nil || !VerifyInclusionProof(proof) || proof.Checkpoint.TreeSize != 6 {
+		t.Fatalf("unexpected proof: %+v, %v", proof, err)
+	}
+	// Older versions can still be proven against the earlier checkpoint.
+	proof, err = svc.ProveVersion(ctx, 2, 2, checkpoint.ID)
+	if err != nil || !VerifyInclusionProof(proof) || proof.Checkpoint.ID != checkpoint.ID {
+		t.Fatalf("unexpected proof: %+v, %v", proof, err)
+	}
+
+	proof.RecordHash = strings.Repeat("0", 64)
+	if VerifyInclusionProof(proof) {
+		t.Fatal("a proof for other content should not verify")
+	}
+
+	// Checkpoints made before subtrees were stored get them when the store opens.
+	if _, err := svc.db.Exec(`DELETE FROM merkle_nodes`); err != nil {
+		t.Fatalf("DELETE: %v", err)
+	}
+	if err := storeCheckpointedMerkleNodes(svc.db); err != nil {
+		t.Fatalf("storeCheckpointedMerkleNodes: %v", err)
+	}
+	proof, err = svc.ProveVersion(ctx, 1, 2, 0)
+	if err != nil || !VerifyInclusionProof(proof) {
+		t.Fatalf("unexpected proof: %+v, %v", proof, err)
+	}
+
+	// Proofs come from the subtrees stored at checkpoint time, so editing a
+	// checkpointed version makes its proof fail rather than changing the tree.
+	if _, err := svc.db.Exec(`UPDATE record_versions SET record_hash = ? WHERE seq = 4`, strings.Repeat("0", 64)); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	proof, err = svc.ProveVersion(ctx, 2, 2, 0)
+	if err != nil || VerifyInclusionProof(proof) {
+		t.Fatalf("expected a proof that does not verify: %+v, %v", proof, err)
+	}
+
+	// Leaf indexes come from seqs, so a removed version is refused rather
+	// than shifting every later leaf.
+	if _, err := svc.db.Exec(`DELETE FROM record_versions WHERE seq = 3`); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	if _, err := svc.CreateCheckpoint(ctx); err != ErrSeqNotContiguous {
+		t.Fatalf("expected ErrSeqNotContiguous, got %v", err)
+	}
+}
+
+func TestMerkleAuditPath(t *testing.T) {
+	ctx := context.Background()
+	for size := int64(1); size <= 17; size++ {
+		// Every node is added, so the tree never reads from a database.
+		tree := newMerkleTree(nil)
+		var leaves [][]byte
+		for i := int64(0); i < size; i++ {
+			leaves = append(leaves, merkleLeafHash([]byte{byte(i)}))
+			if err := tree.appendLeaf(ctx, i, leaves[i]); err != nil {
+				t.Fatalf("appendLeaf: %v", err)
+			}
+		}
+		root, err := tree.rangeHash(ctx, 0, size)
+		if err != nil {
+			t.Fatalf("rangeHash: %v", err)
+		}
+		for index := int64(0); index < size; index++ {
+			path, err := tree.auditPath(ctx, index, 0, size)
+			if err != nil {
+				t.Fatalf("auditPath: %v", err)
+			}
+			if !merkleVerifyInclusion(index, size, leaves[index], path, root) {
+				t.Fatalf("leaf %d of %d does not verify", index, size)
+			}
+			if size > 1 && merkleVerifyInclusion(index, size, leaves[(index+1)%size], path, root) {
+				t.Fatalf("the wrong leaf verified at %d of %d", index, size)
+			}
+		}
+	}
+}
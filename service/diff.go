@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// DiffRecordVersions compares two versions of a record fetched through GetRecordVersion.
+func (s *DBRecordService) DiffRecordVersions(ctx context.Context, id int, fromVersion int, toVersion int) (entity.RecordDiff, error) {
+	from, err := s.GetRecordVersion(ctx, id, fromVersion)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+	to, err := s.GetRecordVersion(ctx, id, toVersion)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+
+	return diffRecordVersions(from, to), nil
+}
+
+// diffRecordVersions reports the keys added, removed and changed going from `from` to `to`.
+func diffRecordVersions(from entity.RecordVersion, to entity.RecordVersion) entity.RecordDiff {
+	diff := entity.RecordDiff{
+		ID:          to.ID,
+		FromVersion: from.Version,
+		ToVersion:   to.Version,
+		Added:       map[string]interface{}{},
+		Removed:     map[string]interface{}{},
+		Changed:     map[string]entity.ValueChange{},
+	}
+
+	for key, oldValue := range from.Data {
+		newValue, ok := to.Data[key]
+		if !ok {
+			diff.Removed[key] = oldValue
+		} else if !reflect.DeepEqual(newValue, oldValue) {
+			diff.Changed[key] = entity.ValueChange{Old: oldValue, New: newValue}
+		}
+	}
+	for key, newValue := range to.Data {
+		if _, ok := from.Data[key]; !ok {
+			diff.Added[key] = newValue
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+func (s *DBRecordService) FieldHistory(ctx context.Context, id int, key string) (entity.FieldHistory, error) {
+	if id <= 0 {
+		return entity.FieldHistory{}, ErrRecordIDInvalid
+	}
+	path, err := jsonPath(key)
+	if err != nil {
+		return entity.FieldHistory{}, err
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT version, created_at_ms, effective_from_ms, actor, reason, data_json -> ?
+		 FROM record_versions
+		 WHERE collection = ? AND record_id = ?
+		 ORDER BY version ASC`,
+		path,
+		s.collection,
+		id,
+	)
+	if err != nil {
+		return entity.FieldHistory{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	result := entity.FieldHistory{ID: id, Key: key, Changes: []entity.FieldChange{}}
+	found := false
+	var previous sql.NullString
+	for rows.Next() {
+		found = true
+
+		var (
+			change entity.FieldChange
+			actor  sql.NullString
+			reason sql.NullString
+			value  sql.NullString
+		)
+		if err := rows.Scan(&change.Version, &change.CreatedAtMS, &change.EffectiveFromMS, &actor, &reason, &value); err != nil {
+			return entity.FieldHistory{}, err
+		}
+
+		// SQLite renders JSON minified, so equal values compare equal as text.
+		// Only versions where the key was set, changed or removed are reported.
+		if value == previous {
+			continue
+		}
+		previous = value
+
+		change.Actor = actor.String
+		change.Reason = reason.String
+		if value.Valid {
+			if change.Value, err = decodeJSONValue(value.String); err != nil {
+				return entity.FieldHistory{}, err
+			}
+		} else {
+			change.Removed = true
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.FieldHistory{}, err
+	}
+
+	if !found {
+		return entity.FieldHistory{}, ErrRecordDoesNotExist
+	}
+
+	return result, nil
+}
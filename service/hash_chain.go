@@ -0,0 +1,396 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// VerifyService checks that history has not been edited since it was
+// written. Each version stores a hash of its content chained to the
+// record's previous version, and a chain hash linking it to the version
+// written before it in any record.
+type VerifyService interface {
+	// VerifyRecord walks both chains through every version of a record.
+	VerifyRecord(ctx context.Context, id int) (entity.Verification, error)
+	// VerifyAll walks both chains through every version in every collection.
+	VerifyAll(ctx context.Context) (entity.Verification, error)
+}
+
+// hashedVersion is a version row as stored, which is what its hashes cover.
+// Data and Changes are the stored JSON text, so the hashes do not depend on
+// how a version decodes.
+type hashedVersion struct {
+	Collection        string `json:"collection"`
+	ID                int    `json:"id"`
+	Version           int    `json:"version"`
+	Seq               int64  `json:"seq"`
+	CreatedAtMS       int64  `json:"created_at_ms"`
+	EffectiveFromMS   int64  `json:"effective_from_ms"`
+	EffectiveToMS     *int64 `json:"effective_to_ms"`
+	Data              string `json:"data"`
+	Changes           string `json:"changes"`
+	RevertedToVersion *int64 `json:"reverted_to_version"`
+	Deleted           bool   `json:"deleted"`
+	Actor             string `json:"actor"`
+	Reason            string `json:"reason"`
+	TransactionID     int64  `json:"transaction_id"`
+
+	recordHash string
+	chainHash  string
+}
+
+const hashedVersionColumns = "collection, record_id, version, seq, created_at_ms, effective_from_ms, effective_to_ms, data_json, changes_json, reverted_to_version, deleted, actor, reason, transaction_id, record_hash, chain_hash"
+
+func scanHashedVersion(row rowScanner) (hashedVersion, error) {
+	var version hashedVersion
+	var (
+		effectiveToMS sql.NullInt64
+		changesJSON   sql.NullString
+		revertedTo    sql.NullInt64
+		actor         sql.NullString
+		reason        sql.NullString
+		transactionID sql.NullInt64
+		recordHash    sql.NullString
+		chainHash     sql.NullString
+	)
+	if err := row.Scan(
+		&version.Collection,
+		&version.ID,
+		&version.Version,
+		&version.Seq,
+		&version.CreatedAtMS,
+		&version.EffectiveFromMS,
+		&effectiveToMS,
+		&version.Data,
+		&changesJSON,
+		&revertedTo,
+		&version.Deleted,
+		&actor,
+		&reason,
+		&transactionID,
+		&recordHash,
+		&chainHash,
+	); err != nil {
+		return hashedVersion{}, err
+	}
+	if effectiveToMS.Valid {
+		version.EffectiveToMS = &effectiveToMS.Int64
+	}
+	if revertedTo.Valid {
+		version.RevertedToVersion = &revertedTo.Int64
+	}
+	version.Changes = changesJSON.String
+	version.Actor = actor.String
+	version.Reason = reason.String
+	version.TransactionID = transactionID.Int64
+	version.recordHash = recordHash.String
+	version.chainHash = chainHash.String
+	return version, nil
+}
+
+// hashedVersionOf is the hashed form of a version about to be inserted.
+func hashedVersionOf(collection string, seq int64, recordVersion entity.RecordVersion, dataJSON, changesJSON string) hashedVersion {
+	version := hashedVersion{
+		Collection:      collection,
+		ID:              recordVersion.ID,
+		Version:         recordVersion.Version,
+		Seq:             seq,
+		CreatedAtMS:     recordVersion.CreatedAtMS,
+		EffectiveFromMS: recordVersion.EffectiveFromMS,
+		EffectiveToMS:   recordVersion.EffectiveToMS,
+		Data:            dataJSON,
+		Changes:         changesJSON,
+		Deleted:         recordVersion.Deleted,
+		Actor:           recordVersion.Actor,
+		Reason:          recordVersion.Reason,
+		TransactionID:   int64(recordVersion.TransactionID),
+	}
+	if recordVersion.RevertedToVersion != nil {
+		revertedTo := int64(*recordVersion.RevertedToVersion)
+		version.RevertedToVersion = &revertedTo
+	}
+	return version
+}
+
+// hashRecord is a version's hash given the hash of the record's previous
+// version, which is empty for the first.
+func (v hashedVersion) hashRecord(previous string) (string, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return linkHash(previous, string(content)), nil
+}
+
+// linkHash hashes content onto the hash of the link before it.
+func linkHash(previous, content string) string {
+	sum := sha256.Sum256([]byte(previous + "\n" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+// chainVerifier checks versions one at a time against the versions before them.
+type chainVerifier struct {
+	verification entity.Verification
+}
+
+// check verifies a version given the record's previous version (nil for
+// the first) and the chain hash of the version before it in the global
+// chain. It returns false once a link is broken.
+func (c *chainVerifier) check(version hashedVersion, previous *hashedVersion, previousChainHash string) (bool, error) {
+	broken := func(chain, reason string) (bool, error) {
+		c.verification.Valid = false
+		c.verification.Broken = &entity.BrokenLink{
+			Collection: version.Collection,
+			ID:         version.ID,
+			Version:    version.Version,
+			Seq:        version.Seq,
+			Chain:      chain,
+			Reason:     reason,
+		}
+		return false, nil
+	}
+
+	previousHash := ""
+	expectedVersion := 1
+	if previous != nil {
+		previousHash = previous.recordHash
+		expectedVersion = previous.Version + 1
+	}
+	if version.Version != expectedVersion {
+		return broken(entity.ChainRecord, fmt.Sprintf("version %d is missing", expectedVersion))
+	}
+	if version.recordHash == "" || version.chainHash == "" {
+		return broken(entity.ChainRecord, "version has no hash")
+	}
+	recordHash, err := version.hashRecord(previousHash)
+	if err != nil {
+		return false, err
+	}
+	if version.recordHash != recordHash {
+		return broken(entity.ChainRecord, "hash does not match the version's content and the previous version's hash")
+	}
+	if version.chainHash != linkHash(previousChainHash, version.recordHash) {
+		return broken(entity.ChainGlobal, "chain hash does not match the version's hash and the previous version's chain hash")
+	}
+
+	c.verification.Checked++
+	return true, nil
+}
+
+func (s *DBRecordService) VerifyRecord(ctx context.Context, id int) (entity.Verification, error) {
+	if id <= 0 {
+		return entity.Verification{}, ErrRecordIDInvalid
+	}
+
+	tx, err := s.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Verification{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Each version comes with the chain hash of the version written just
+	// before it, whichever record that was.
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT `+hashedVersionColumns+`,
+		   (SELECT p.chain_hash FROM record_versions p WHERE p.seq < rv.seq ORDER BY p.seq DESC LIMIT 1)
+		 FROM record_versions rv
+		 WHERE collection = ? AND record_id = ?
+		 ORDER BY version ASC`,
+		s.collection,
+		id,
+	)
+	if err != nil {
+		return entity.Verification{}, err
+	}
+	var versions []hashedVersion
+	var previousChainHashes []sql.NullString
+	for rows.Next() {
+		var previousChainHash sql.NullString
+		version, err := scanHashedVersion(previousChainScanner{row: rows, previousChainHash: &previousChainHash})
+		if err != nil {
+			_ = rows.Close()
+			return entity.Verification{}, err
+		}
+		versions = append(versions, version)
+		previousChainHashes = append(previousChainHashes, previousChainHash)
+	}
+	if err := rows.Close(); err != nil {
+		return entity.Verification{}, err
+	}
+	if len(versions) == 0 {
+		return entity.Verification{}, ErrRecordDoesNotExist
+	}
+
+	verifier := chainVerifier{verification: entity.Verification{Valid: true}}
+	var previous *hashedVersion
+	for i := range versions {
+		ok, err := verifier.check(versions[i], previous, previousChainHashes[i].String)
+		if err != nil {
+			return entity.Verification{}, err
+		}
+		if !ok {
+			break
+		}
+		previous = &versions[i]
+	}
+	return verifier.verification, nil
+}
+
+// previousChainScanner scans a row selected with hashedVersionColumns
+// followed by the chain hash of the version before it.
+type previousChainScanner struct {
+	row               rowScanner
+	previousChainHash *sql.NullString
+}
+
+func (p previousChainScanner) Scan(dest ...interface{}) error {
+	return p.row.Scan(append(dest, p.previousChainHash)...)
+}
+
+func (s *DBRecordService) VerifyAll(ctx context.Context) (entity.Verification, error) {
+	tx, err := s.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Verification{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+hashedVersionColumns+` FROM record_versions ORDER BY seq ASC`)
+	if err != nil {
+		return entity.Verification{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	verifier := chainVerifier{verification: entity.Verification{Valid: true}}
+	latest := map[recordKey]hashedVersion{}
+	previousChainHash := ""
+	for rows.Next() {
+		version, err := scanHashedVersion(rows)
+		if err != nil {
+			return entity.Verification{}, err
+		}
+
+		key := recordKey{version.Collection, version.ID}
+		var previous *hashedVersion
+		if latestVersion, ok := latest[key]; ok {
+			previous = &latestVersion
+		}
+		ok, err := verifier.check(version, previous, previousChainHash)
+		if err != nil {
+			return entity.Verification{}, err
+		}
+		if !ok {
+			break
+		}
+		latest[key] = version
+		previousChainHash = version.chainHash
+	}
+	if err := rows.Err(); err != nil {
+		return entity.Verification{}, err
+	}
+	return verifier.verification, nil
+}
+
+// recordKey identifies a record across collections.
+type recordKey struct {
+	collection string
+	id         int
+}
+
+// versionHashesMigration names backfillVersionHashes in the migrations table.
+const versionHashesMigration = "version_hashes"
+
+// backfillVersionHashes hashes versions written before the hash chains
+// existed, continuing the chains in seq order. It runs once per database;
+// after that, a version without hashes is a broken link rather than one to
+// seal, so nulling a tampered version's hashes cannot get it re-hashed.
+func backfillVersionHashes(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var marker int
+	err = tx.QueryRow(`SELECT 1 FROM migrations WHERE name = ?`, versionHashesMigration).Scan(&marker)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	// A database that already has hashes was hashing every write and has
+	// nothing to backfill.
+	err = tx.QueryRow(`SELECT 1 FROM record_versions WHERE chain_hash IS NOT NULL LIMIT 1`).Scan(&marker)
+	switch {
+	case err == sql.ErrNoRows:
+		if err := hashUnhashedVersions(tx); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO migrations (name, applied_at_ms) VALUES (?, ?)`,
+		versionHashesMigration,
+		time.Now().UTC().UnixMilli(),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// hashUnhashedVersions sets the hashes of every version that has none.
+func hashUnhashedVersions(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT ` + hashedVersionColumns + ` FROM record_versions ORDER BY seq ASC`)
+	if err != nil {
+		return err
+	}
+	latestHash := map[recordKey]string{}
+	previousChainHash := ""
+	var hashed []hashedVersion
+	for rows.Next() {
+		version, err := scanHashedVersion(rows)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		key := recordKey{version.Collection, version.ID}
+		if version.chainHash == "" {
+			if version.recordHash, err = version.hashRecord(latestHash[key]); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			version.chainHash = linkHash(previousChainHash, version.recordHash)
+			hashed = append(hashed, version)
+		}
+		latestHash[key] = version.recordHash
+		previousChainHash = version.chainHash
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, version := range hashed {
+		if _, err := tx.Exec(
+			`UPDATE record_versions SET record_hash = ?, chain_hash = ? WHERE collection = ? AND record_id = ? AND version = ?`,
+			version.recordHash,
+			version.chainHash,
+			version.Collection,
+			version.ID,
+			version.Version,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// The formats ImportRecords reads.
+const (
+	ImportFormatNDJSON = "ndjson"
+	ImportFormatCSV    = "csv"
+)
+
+// DefaultImportBatchSize is how many records ImportRecords writes per transaction.
+const DefaultImportBatchSize = 500
+
+var ErrImportFormatInvalid = errors.New("import format must be ndjson or csv")
+var ErrImportBatchSizeInvalid = errors.New("import batch size must be positive")
+var ErrImportHeaderInvalid = errors.New(`csv imports need a header row with an "id" column`)
+
+// ImportOptions controls ImportRecords.
+type ImportOptions struct {
+	// Format is ImportFormatNDJSON or ImportFormatCSV.
+	//
+	// NDJSON has one {"id": n, "data": {...}} object per line, the same shape
+	// as a snapshot line. CSV has a header row with an "id" column and one
+	// column per key; empty cells leave the key out.
+	Format string
+
+	// BatchSize is how many records are written per transaction; 0 means
+	// DefaultImportBatchSize.
+	BatchSize int
+
+	// DryRun checks every line, including against schemas, without writing.
+	DryRun bool
+
+	// WriteOptions apply to every version written: their actor, reason and
+	// effective range. Expected versions are ignored.
+	WriteOptions WriteOptions
+}
+
+// ImportService loads many records at once.
+type ImportService interface {
+	// ImportRecords streams records from r into this service's collection.
+	// Each record is created, or has all of its data replaced if it exists.
+	// Lines that cannot be parsed or written are reported and skipped; the
+	// returned error is only for failures of the import as a whole.
+	ImportRecords(ctx context.Context, r io.Reader, opts ImportOptions) (entity.ImportReport, error)
+}
+
+// importLine is a parsed line waiting to be written.
+type importLine struct {
+	line int
+	op   entity.Operation
+}
+
+// importReader yields the records of an import one at a time. It returns
+// io.EOF once the input is exhausted, and a *lineError for lines that could
+// not be parsed.
+type importReader interface {
+	next() (importLine, error)
+}
+
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return e.err.Error()
+}
+
+func (s *DBRecordService) ImportRecords(ctx context.Context, r io.Reader, opts ImportOptions) (entity.ImportReport, error) {
+	if opts.BatchSize == 0 {
+		opts.BatchSize = DefaultImportBatchSize
+	}
+	if opts.BatchSize < 0 {
+		return entity.ImportReport{}, ErrImportBatchSizeInvalid
+	}
+
+	var reader importReader
+	switch opts.Format {
+	case ImportFormatNDJSON:
+		reader = &ndjsonImportReader{reader: bufio.NewReader(r)}
+	case ImportFormatCSV:
+		reader = &csvImportReader{reader: csv.NewReader(r)}
+	default:
+		return entity.ImportReport{}, ErrImportFormatInvalid
+	}
+
+	report := entity.ImportReport{DryRun: opts.DryRun, Errors: []entity.ImportError{}}
+	batch := make([]importLine, 0, opts.BatchSize)
+	for {
+		line, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		var lineErr *lineError
+		if errors.As(err, &lineErr) {
+			report.Lines++
+			report.Errors = append(report.Errors, entity.ImportError{Line: lineErr.line, Error: lineErr.Error()})
+			continue
+		}
+		if err != nil {
+			return entity.ImportReport{}, err
+		}
+
+		report.Lines++
+		batch = append(batch, line)
+		if len(batch) == opts.BatchSize {
+			if err := s.importBatch(ctx, batch, opts, &report); err != nil {
+				return entity.ImportReport{}, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := s.importBatch(ctx, batch, opts, &report); err != nil {
+		return entity.ImportReport{}, err
+	}
+
+	report.Failed = len(report.Errors)
+	return report, nil
+}
+
+// importBatch writes a batch in one transaction, or only checks it on a
+// dry run. Each line is written once; a line that is rejected is undone,
+// reported and left out of the batch.
+func (s *DBRecordService) importBatch(ctx context.Context, batch []importLine, opts ImportOptions, report *entity.ImportReport) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if opts.DryRun {
+		return s.checkImportBatch(ctx, batch, opts, report)
+	}
+
+	writeOpts := opts.WriteOptions
+	writeOpts.ExpectedVersion = nil
+	ops := make([]entity.Operation, len(batch))
+	for i, line := range batch {
+		ops[i] = line.op
+	}
+	reject := func(index int, err error) bool {
+		if !isRejectedWrite(err) {
+			return false
+		}
+		report.Errors = append(report.Errors, importError(batch[index], err))
+		return true
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// The batch is written at one time, but it is not a transaction
+	// callers can look up.
+	services, createdAtMS, err := s.prepareOperations(ctx, tx, ops, reject)
+	if err != nil {
+		return err
+	}
+	writeOpts.createdAtMS = createdAtMS
+	versions, err := applyOperations(ctx, tx, ops, services, writeOpts, reject)
+	if err != nil {
+		return err
+	}
+	if len(versions) > 0 {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	report.Imported += len(versions)
+	return nil
+}
+
+// checkImportBatch runs the checks importBatch's writes would, from a read
+// transaction so a dry run never waits on or holds up writers.
+func (s *DBRecordService) checkImportBatch(ctx context.Context, batch []importLine, opts ImportOptions, report *entity.ImportReport) error {
+	tx, err := s.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	createdAtMS := time.Now().UTC().UnixMilli()
+	for _, line := range batch {
+		if err := s.checkImportLine(ctx, tx, line.op, opts.WriteOptions, createdAtMS); err != nil {
+			if !isRejectedWrite(err) {
+				return err
+			}
+			report.Errors = append(report.Errors, importError(line, err))
+			continue
+		}
+		report.Imported++
+	}
+	return nil
+}
+
+// checkImportLine checks that op, a put, would be accepted, without writing it.
+func (s *DBRecordService) checkImportLine(ctx context.Context, tx *sql.Tx, op entity.Operation, opts WriteOptions, createdAtMS int64) error {
+	scoped, err := s.operationService(ctx, tx, op)
+	if err != nil {
+		return err
+	}
+	if _, _, err := opts.effectiveRange(createdAtMS); err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{}, len(op.Data))
+	for key, value := range op.Data {
+		if value != nil {
+			data[key] = value
+		}
+	}
+	return scoped.validateRecordData(ctx, tx, op.ID, data)
+}
+
+// importError reports a rejected line.
+func importError(line importLine, err error) entity.ImportError {
+	importErr := entity.ImportError{Line: line.line, ID: line.op.ID, Error: err.Error()}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		importErr.Fields = validationErr.Fields
+	}
+	return importErr
+}
+
+// isRejectedWrite reports whether err is about the record being written
+// rather than the store.
+func isRejectedWrite(err error) bool {
+	for _, rejection := range []error{
+		ErrRecordIDInvalid,
+		ErrOperationInvalid,
+		ErrValidationFailed,
+		ErrSchemaDoesNotExist,
+		ErrEffectiveRangeInvalid,
+		ErrBackdatedUpdate,
+		ErrRecordAlreadyExists,
+	} {
+		if errors.Is(err, rejection) {
+			return true
+		}
+	}
+	return false
+}
+
+type ndjsonImportReader struct {
+	reader *bufio.Reader
+	line   int
+}
+
+func (r *ndjsonImportReader) next() (importLine, error) {
+	for {
+		text, err := r.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || text == "") {
+			return importLine{}, err
+		}
+		r.line++
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		var record struct {
+			ID   int                    `json:"id"`
+			Data map[string]interface{} `json:"data"`
+		}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			return importLine{}, &lineError{line: r.line, err: errors.New("invalid json: " + err.Error())}
+		}
+		return importLine{
+			line: r.line,
+			op:   entity.Operation{Op: entity.OperationPut, ID: record.ID, Data: record.Data},
+		}, nil
+	}
+}
+
+type csvImportReader struct {
+	reader   *csv.Reader
+	header   []string
+	idColumn int
+}
+
+func (r *csvImportReader) next() (importLine, error) {
+	if r.header == nil {
+		header, err := r.reader.Read()
+		if err != nil {
+			return importLine{}, err
+		}
+		r.header = header
+		r.idColumn = -1
+		for i, column := range header {
+			if column == "id" {
+				r.idColumn = i
+			}
+		}
+		if r.idColumn < 0 {
+			return importLine{}, ErrImportHeaderInvalid
+		}
+	}
+
+	fields, err := r.reader.Read()
+	if err == io.EOF {
+		return importLine{}, err
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return importLine{}, &lineError{line: parseErr.StartLine, err: parseErr.Err}
+		}
+		return importLine{}, err
+	}
+	line, _ := r.reader.FieldPos(0)
+
+	id, err := strconv.Atoi(fields[r.idColumn])
+	if err != nil {
+		return importLine{}, &lineError{line: line, err: errors.New("invalid id; id must be a positive number")}
+	}
+	data := make(map[string]interface{}, len(fields)-1)
+	for i, value := range fields {
+		if i != r.idColumn && value != "" {
+			data[r.header[i]] = value
+		}
+	}
+	return importLine{
+		line: line,
+		op:   entity.Operation{Op: entity.OperationPut, ID: id, Data: data},
+	}, nil
+}
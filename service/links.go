@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+var ErrLinkTypeInvalid = errors.New("link types must start with a lowercase letter and may only contain lowercase letters, digits, '_' and '-'")
+var ErrLinkTargetDoesNotExist = errors.New("linked record does not exist")
+var ErrLinkDoesNotExist = errors.New("link does not exist")
+
+// LinkService keeps typed links from the records of a collection to records
+// in any collection. Links are versioned like records, so a record can be
+// read together with the records it linked to at any point in time.
+type LinkService interface {
+	// LinkRecord adds a link from a record; the target has to exist. Adding a
+	// link that is already there returns it unchanged.
+	LinkRecord(ctx context.Context, id int, link entity.Link, opts WriteOptions) (entity.Link, error)
+	// UnlinkRecord removes a link from a record.
+	UnlinkRecord(ctx context.Context, id int, link entity.Link, opts WriteOptions) (entity.Link, error)
+	// ListLinks returns a record's links as they were at atMS.
+	ListLinks(ctx context.Context, id int, atMS int64) ([]entity.Link, error)
+	// ExpandRecord returns a record as it was at atMS together with the
+	// records it linked to, also as they were at atMS.
+	ExpandRecord(ctx context.Context, id int, atMS int64) (entity.ExpandedRecord, error)
+}
+
+var linkTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// linkColumns is the column list scanned by scanLink.
+const linkColumns = `link_type, target_collection, target_id, version, created_at_ms, removed, actor, reason`
+
+func (s *DBRecordService) LinkRecord(ctx context.Context, id int, link entity.Link, opts WriteOptions) (entity.Link, error) {
+	return s.writeLink(ctx, id, link, false, opts)
+}
+
+func (s *DBRecordService) UnlinkRecord(ctx context.Context, id int, link entity.Link, opts WriteOptions) (entity.Link, error) {
+	return s.writeLink(ctx, id, link, true, opts)
+}
+
+func (s *DBRecordService) writeLink(ctx context.Context, id int, link entity.Link, removed bool, opts WriteOptions) (entity.Link, error) {
+	if id <= 0 || link.ID <= 0 {
+		return entity.Link{}, ErrRecordIDInvalid
+	}
+	if !linkTypePattern.MatchString(link.Type) {
+		return entity.Link{}, ErrLinkTypeInvalid
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Link{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := s.latestLiveRecordVersion(ctx, tx, id); err != nil {
+		return entity.Link{}, err
+	}
+
+	row := tx.QueryRowContext(
+		ctx,
+		`SELECT `+linkColumns+` FROM record_links
+		 WHERE collection = ? AND record_id = ? AND link_type = ? AND target_collection = ? AND target_id = ?
+		 ORDER BY version DESC LIMIT 1`,
+		s.collection,
+		id,
+		link.Type,
+		link.Collection,
+		link.ID,
+	)
+	current, err := scanLink(row)
+	switch {
+	case err == sql.ErrNoRows:
+		current = entity.Link{Removed: true}
+	case err != nil:
+		return entity.Link{}, err
+	}
+
+	if removed {
+		if current.Removed {
+			return entity.Link{}, ErrLinkDoesNotExist
+		}
+	} else {
+		if !current.Removed {
+			return current, nil
+		}
+		if _, err := s.getCollection(ctx, tx, link.Collection); err != nil {
+			if err == ErrCollectionDoesNotExist {
+				return entity.Link{}, ErrLinkTargetDoesNotExist
+			}
+			return entity.Link{}, err
+		}
+		if _, err := s.inCollection(link.Collection).latestLiveRecordVersion(ctx, tx, link.ID); err != nil {
+			if err == ErrRecordDoesNotExist {
+				return entity.Link{}, ErrLinkTargetDoesNotExist
+			}
+			return entity.Link{}, err
+		}
+	}
+
+	// Link versions written within the same millisecond share it, and the
+	// highest one wins, so a read at the current time sees every write so far.
+	createdAtMS := opts.createdAtMS
+	if createdAtMS == 0 {
+		createdAtMS = time.Now().UTC().UnixMilli()
+		if createdAtMS < current.CreatedAtMS {
+			createdAtMS = current.CreatedAtMS
+		}
+	}
+	next := entity.Link{
+		Type:        link.Type,
+		Collection:  link.Collection,
+		ID:          link.ID,
+		Version:     current.Version + 1,
+		CreatedAtMS: createdAtMS,
+		Removed:     removed,
+		Actor:       opts.Actor,
+		Reason:      opts.Reason,
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO record_links (
+			collection, record_id, link_type, target_collection, target_id,
+			version, created_at_ms, removed, actor, reason
+		 ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.collection,
+		id,
+		next.Type,
+		next.Collection,
+		next.ID,
+		next.Version,
+		next.CreatedAtMS,
+		next.Removed,
+		sql.NullString{String: next.Actor, Valid: next.Actor != ""},
+		sql.NullString{String: next.Reason, Valid: next.Reason != ""},
+	); err != nil {
+		return entity.Link{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.Link{}, err
+	}
+
+	return next, nil
+}
+
+func (s *DBRecordService) ListLinks(ctx context.Context, id int, atMS int64) ([]entity.Link, error) {
+	if id <= 0 {
+		return nil, ErrRecordIDInvalid
+	}
+	return s.linksAt(ctx, s.db, id, atMS)
+}
+
+func (s *DBRecordService) ExpandRecord(ctx context.Context, id int, atMS int64) (entity.ExpandedRecord, error) {
+	if id <= 0 {
+		return entity.ExpandedRecord{}, ErrRecordIDInvalid
+	}
+
+	// A single read transaction reads every record at the same point in time.
+	tx, err := s.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.ExpandedRecord{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	recordVersion, err := s.recordVersionAt(ctx, tx, id, atMS)
+	if err != nil {
+		return entity.ExpandedRecord{}, err
+	}
+	links, err := s.linksAt(ctx, tx, id, atMS)
+	if err != nil {
+		return entity.ExpandedRecord{}, err
+	}
+
+	result := entity.ExpandedRecord{AtMS: atMS, Record: recordVersion, Links: []entity.ExpandedLink{}}
+	for _, link := range links {
+		expanded := entity.ExpandedLink{Link: link}
+		target, err := s.inCollection(link.Collection).recordVersionAt(ctx, tx, link.ID, atMS)
+		switch {
+		case err == nil:
+			expanded.Record = &target
+		case err != ErrRecordDoesNotExist:
+			return entity.ExpandedRecord{}, err
+		}
+		result.Links = append(result.Links, expanded)
+	}
+
+	return result, nil
+}
+
+// linksAt reads the links a record had at atMS.
+func (s *DBRecordService) linksAt(ctx context.Context, db queryer, id int, atMS int64) ([]entity.Link, error) {
+	// Each link's state is its highest version recorded by atMS.
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT `+linkColumns+`
+		 FROM record_links l
+		 WHERE l.collection = ? AND l.record_id = ?
+		   AND l.version = (
+			SELECT MAX(version) FROM record_links latest
+			WHERE latest.collection = l.collection AND latest.record_id = l.record_id
+			  AND latest.link_type = l.link_type
+			  AND latest.target_collection = l.target_collection AND latest.target_id = l.target_id
+			  AND latest.created_at_ms <= ?
+		   )
+		   AND l.removed = 0
+		 ORDER BY l.link_type ASC, l.target_collection ASC, l.target_id ASC`,
+		s.collection,
+		id,
+		atMS,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	links := []entity.Link{}
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func scanLink(row rowScanner) (entity.Link, error) {
+	var link entity.Link
+	var actor, reason sql.NullString
+	if err := row.Scan(
+		&link.Type,
+		&link.Collection,
+		&link.ID,
+		&link.Version,
+		&link.CreatedAtMS,
+		&link.Removed,
+		&actor,
+		&reason,
+	); err != nil {
+		return entity.Link{}, err
+	}
+	link.Actor = actor.String
+	link.Reason = reason.String
+	return link, nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// ListRecordsOptions controls which records ListRecords returns.
+type ListRecordsOptions struct {
+	// Limit caps the number of records returned; 0 means no limit. When
+	// more records remain, the result's NextCursor is set.
+	Limit int
+
+	// AfterID resumes a listing from a previous NextCursor.
+	AfterID int
+
+	// Where keeps only records whose data has every key set to the given
+	// value. Non-string values match their JSON encoding, e.g. "5" or "true".
+	Where map[string]string
+
+	// AtMS evaluates the list as it was at that time instead of now.
+	AtMS *int64
+}
+
+func (s *DBRecordService) ListRecords(ctx context.Context, opts ListRecordsOptions) (entity.RecordList, error) {
+	if opts.Limit < 0 || opts.AfterID < 0 {
+		return entity.RecordList{}, ErrListOptionsInvalid
+	}
+
+	query, args := currentVersionsQuery(s.collection, opts.AtMS)
+	query += ` AND rv.record_id > ?`
+	args = append(args, opts.AfterID)
+	for key, value := range opts.Where {
+		path, err := jsonPath(key)
+		if err != nil {
+			return entity.RecordList{}, err
+		}
+		// Values compare the way the v1 API renders them: strings as-is,
+		// everything else as JSON.
+		query += ` AND (CASE json_type(rv.data_json, ?) WHEN 'text' THEN json_extract(rv.data_json, ?) ELSE rv.data_json -> ? END) = ?`
+		args = append(args, path, path, path, value)
+	}
+	query += ` ORDER BY rv.record_id ASC`
+	if opts.Limit > 0 {
+		// One extra row tells us whether there is a next page.
+		query += ` LIMIT ?`
+		args = append(args, opts.Limit+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entity.RecordList{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	result := entity.RecordList{Records: []entity.RecordVersion{}}
+	for rows.Next() {
+		recordVersion, err := scanRecordVersion(rows)
+		if err != nil {
+			return entity.RecordList{}, err
+		}
+		result.Records = append(result.Records, recordVersion)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.RecordList{}, err
+	}
+
+	if opts.Limit > 0 && len(result.Records) > opts.Limit {
+		result.Records = result.Records[:opts.Limit]
+		nextCursor := result.Records[opts.Limit-1].ID
+		result.NextCursor = &nextCursor
+	}
+
+	return result, nil
+}
+
+func (s *DBRecordService) SnapshotAt(ctx context.Context, atMS int64, fn func(entity.RecordVersion) error) error {
+	// A single read transaction keeps every record at the same point in time
+	// even if writes land while the snapshot is streaming, and doesn't hold
+	// up those writes however slowly fn consumes it.
+	tx, err := s.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query, args := currentVersionsQuery(s.collection, &atMS)
+	rows, err := tx.QueryContext(ctx, query+` ORDER BY rv.record_id ASC`, args...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		recordVersion, err := scanRecordVersion(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(recordVersion); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// currentVersionsQuery selects every live record of a collection at the
+// version that was current at atMS (or now, if nil). Callers may append
+// further AND clauses.
+func currentVersionsQuery(collection string, atMS *int64) (string, []interface{}) {
+	// Each record's current version is the highest one recorded by atMS;
+	// the (collection, record_id, version) primary key serves the correlated lookup.
+	latestVersion := `SELECT MAX(version) FROM record_versions latest
+		WHERE latest.collection = rv.collection AND latest.record_id = rv.record_id`
+	args := []interface{}{collection}
+	if atMS != nil {
+		latestVersion += ` AND latest.created_at_ms <= ?`
+		args = append(args, *atMS)
+	}
+
+	query := `SELECT ` + recordVersionColumns + `
+		FROM record_versions rv
+		WHERE rv.collection = ?
+		  AND rv.version = (` + latestVersion + `)
+		  AND rv.deleted = 0`
+	return query, args
+}
+
+// jsonPath builds the SQLite JSON path selecting a top-level key.
+func jsonPath(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `"\`) {
+		return "", ErrDataKeyInvalid
+	}
+	return `$."` + key + `"`, nil
+}
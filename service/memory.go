@@ -0,0 +1,604 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// MemoryRecordService keeps records in memory with the same semantics as
+// DBRecordService for everything in VersionedRecordService. It has a single
+// collection and none of the optional services, and everything in it is lost
+// when the process exits. It is safe for concurrent use.
+type MemoryRecordService struct {
+	mu      sync.RWMutex
+	records map[int][]memoryVersion
+}
+
+func NewMemoryRecordService() *MemoryRecordService {
+	return &MemoryRecordService{records: map[int][]memoryVersion{}}
+}
+
+// memoryVersion is a stored version. Its data and changes are kept as JSON,
+// the way DBRecordService stores them, so they read back the same way and
+// callers cannot alter history through the maps they are handed.
+type memoryVersion struct {
+	meta        entity.RecordVersion
+	dataJSON    string
+	changesJSON string
+}
+
+// recordVersion decodes a stored version into a fresh entity.RecordVersion.
+func (v memoryVersion) recordVersion() (entity.RecordVersion, error) {
+	recordVersion := v.meta
+	if v.meta.EffectiveToMS != nil {
+		effectiveToMS := *v.meta.EffectiveToMS
+		recordVersion.EffectiveToMS = &effectiveToMS
+	}
+	if v.meta.RevertedToVersion != nil {
+		revertedToVersion := *v.meta.RevertedToVersion
+		recordVersion.RevertedToVersion = &revertedToVersion
+	}
+
+	data, err := decodeJSONObject(v.dataJSON)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	recordVersion.Data = data
+
+	if recordVersion.Changes, err = decodeJSONObject(v.changesJSON); err != nil {
+		return entity.RecordVersion{}, err
+	}
+	return recordVersion, nil
+}
+
+func (s *MemoryRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	recordVersion, err := s.GetLatestRecordVersion(ctx, id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	data, err := stringifyData(recordVersion.Data)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{ID: id, Data: data}, nil
+}
+
+func (s *MemoryRecordService) GetLatestRecordVersion(ctx context.Context, id int) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latestLiveRecordVersion(id)
+}
+
+func (s *MemoryRecordService) GetRecordVersionAt(ctx context.Context, id int, atMS int64) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latestMatching(id, func(meta entity.RecordVersion) bool {
+		return meta.CreatedAtMS <= atMS
+	})
+}
+
+func (s *MemoryRecordService) GetRecordVersionAsOf(ctx context.Context, id int, validAtMS int64, asOfMS int64) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	// Of the versions recorded by asOfMS whose effective range covers
+	// validAtMS, the most recently recorded one wins.
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latestMatching(id, func(meta entity.RecordVersion) bool {
+		return meta.CreatedAtMS <= asOfMS &&
+			meta.EffectiveFromMS <= validAtMS &&
+			(meta.EffectiveToMS == nil || *meta.EffectiveToMS > validAtMS)
+	})
+}
+
+// latestMatching returns the most recently recorded version of a record
+// that matches, treating a tombstone as a missing record.
+func (s *MemoryRecordService) latestMatching(id int, match func(entity.RecordVersion) bool) (entity.RecordVersion, error) {
+	var latest *memoryVersion
+	for i, version := range s.records[id] {
+		if !match(version.meta) {
+			continue
+		}
+		if latest == nil || version.meta.CreatedAtMS > latest.meta.CreatedAtMS ||
+			(version.meta.CreatedAtMS == latest.meta.CreatedAtMS && version.meta.Version > latest.meta.Version) {
+			latest = &s.records[id][i]
+		}
+	}
+	if latest == nil || latest.meta.Deleted {
+		return entity.RecordVersion{}, ErrRecordDoesNotExist
+	}
+	return latest.recordVersion()
+}
+
+func (s *MemoryRecordService) GetRecordVersion(ctx context.Context, id int, version int) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+	if version <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	versions := s.records[id]
+	if version > len(versions) {
+		return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
+	}
+	return versions[version-1].recordVersion()
+}
+
+func (s *MemoryRecordService) ListRecordVersions(ctx context.Context, id int, opts ListVersionsOptions) (entity.RecordVersions, error) {
+	if id <= 0 {
+		return entity.RecordVersions{}, ErrRecordIDInvalid
+	}
+	if opts.Limit < 0 || opts.AfterVersion < 0 {
+		return entity.RecordVersions{}, ErrListOptionsInvalid
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	versions := s.records[id]
+	if len(versions) == 0 {
+		return entity.RecordVersions{}, ErrRecordDoesNotExist
+	}
+
+	result := entity.RecordVersions{ID: id, Versions: []entity.RecordVersionInfo{}}
+	for i := range versions {
+		version := versions[i]
+		if opts.Descending {
+			version = versions[len(versions)-1-i]
+		}
+		meta := version.meta
+		if opts.AfterVersion > 0 && ((opts.Descending && meta.Version >= opts.AfterVersion) || (!opts.Descending && meta.Version <= opts.AfterVersion)) {
+			continue
+		}
+		if (opts.FromMS != nil && meta.CreatedAtMS < *opts.FromMS) || (opts.ToMS != nil && meta.CreatedAtMS >= *opts.ToMS) {
+			continue
+		}
+		if opts.Limit > 0 && len(result.Versions) == opts.Limit {
+			nextCursor := result.Versions[opts.Limit-1].Version
+			result.NextCursor = &nextCursor
+			break
+		}
+
+		recordVersion, err := version.recordVersion()
+		if err != nil {
+			return entity.RecordVersions{}, err
+		}
+		info := entity.RecordVersionInfo{
+			Version:           recordVersion.Version,
+			CreatedAtMS:       recordVersion.CreatedAtMS,
+			EffectiveFromMS:   recordVersion.EffectiveFromMS,
+			EffectiveToMS:     recordVersion.EffectiveToMS,
+			RevertedToVersion: recordVersion.RevertedToVersion,
+			Deleted:           recordVersion.Deleted,
+			Actor:             recordVersion.Actor,
+			Reason:            recordVersion.Reason,
+			TransactionID:     recordVersion.TransactionID,
+		}
+		if !opts.OmitData {
+			info.Data = recordVersion.Data
+		}
+		if opts.IncludeChanges {
+			info.Changes = recordVersion.Changes
+		}
+		result.Versions = append(result.Versions, info)
+	}
+
+	return result, nil
+}
+
+func (s *MemoryRecordService) ListRecords(ctx context.Context, opts ListRecordsOptions) (entity.RecordList, error) {
+	if opts.Limit < 0 || opts.AfterID < 0 {
+		return entity.RecordList{}, ErrListOptionsInvalid
+	}
+	for key := range opts.Where {
+		if _, err := jsonPath(key); err != nil {
+			return entity.RecordList{}, err
+		}
+	}
+
+	s.mu.RLock()
+	current, err := s.currentVersions(opts.AtMS)
+	s.mu.RUnlock()
+	if err != nil {
+		return entity.RecordList{}, err
+	}
+
+	result := entity.RecordList{Records: []entity.RecordVersion{}}
+	for _, recordVersion := range current {
+		if recordVersion.ID <= opts.AfterID {
+			continue
+		}
+		// Values compare the way the v1 API renders them: strings as-is,
+		// everything else as JSON.
+		rendered, err := stringifyData(recordVersion.Data)
+		if err != nil {
+			return entity.RecordList{}, err
+		}
+		matches := true
+		for key, value := range opts.Where {
+			if actual, ok := rendered[key]; !ok || actual != value {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		if opts.Limit > 0 && len(result.Records) == opts.Limit {
+			nextCursor := result.Records[opts.Limit-1].ID
+			result.NextCursor = &nextCursor
+			break
+		}
+		result.Records = append(result.Records, recordVersion)
+	}
+
+	return result, nil
+}
+
+func (s *MemoryRecordService) SnapshotAt(ctx context.Context, atMS int64, fn func(entity.RecordVersion) error) error {
+	// Copying the versions out under the lock keeps every record at the
+	// same point in time even if writes land while the snapshot is streaming.
+	s.mu.RLock()
+	current, err := s.currentVersions(&atMS)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for _, recordVersion := range current {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(recordVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentVersions returns every live record at the version that was current
+// at atMS (or now, if nil), ordered by id.
+func (s *MemoryRecordService) currentVersions(atMS *int64) ([]entity.RecordVersion, error) {
+	ids := make([]int, 0, len(s.records))
+	for id := range s.records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	current := []entity.RecordVersion{}
+	for _, id := range ids {
+		// Each record's current version is the highest one recorded by atMS.
+		var latest *memoryVersion
+		for i, version := range s.records[id] {
+			if atMS == nil || version.meta.CreatedAtMS <= *atMS {
+				latest = &s.records[id][i]
+			}
+		}
+		if latest == nil || latest.meta.Deleted {
+			continue
+		}
+		recordVersion, err := latest.recordVersion()
+		if err != nil {
+			return nil, err
+		}
+		current = append(current, recordVersion)
+	}
+	return current, nil
+}
+
+// DiffRecordVersions compares two versions of a record fetched through GetRecordVersion.
+func (s *MemoryRecordService) DiffRecordVersions(ctx context.Context, id int, fromVersion int, toVersion int) (entity.RecordDiff, error) {
+	from, err := s.GetRecordVersion(ctx, id, fromVersion)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+	to, err := s.GetRecordVersion(ctx, id, toVersion)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+
+	return diffRecordVersions(from, to), nil
+}
+
+func (s *MemoryRecordService) FieldHistory(ctx context.Context, id int, key string) (entity.FieldHistory, error) {
+	if id <= 0 {
+		return entity.FieldHistory{}, ErrRecordIDInvalid
+	}
+	if _, err := jsonPath(key); err != nil {
+		return entity.FieldHistory{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	versions := s.records[id]
+	if len(versions) == 0 {
+		return entity.FieldHistory{}, ErrRecordDoesNotExist
+	}
+
+	result := entity.FieldHistory{ID: id, Key: key, Changes: []entity.FieldChange{}}
+	var previous *string
+	for _, version := range versions {
+		recordVersion, err := version.recordVersion()
+		if err != nil {
+			return entity.FieldHistory{}, err
+		}
+
+		// Only versions where the key was set, changed or removed are
+		// reported, comparing values as JSON like DBRecordService does.
+		var current *string
+		value, ok := recordVersion.Data[key]
+		if ok {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return entity.FieldHistory{}, err
+			}
+			text := string(encoded)
+			current = &text
+		}
+		if (current == nil && previous == nil) || (current != nil && previous != nil && *current == *previous) {
+			continue
+		}
+		previous = current
+
+		change := entity.FieldChange{
+			Version:         recordVersion.Version,
+			CreatedAtMS:     recordVersion.CreatedAtMS,
+			EffectiveFromMS: recordVersion.EffectiveFromMS,
+			Actor:           recordVersion.Actor,
+			Reason:          recordVersion.Reason,
+		}
+		if ok {
+			change.Value = value
+		} else {
+			change.Removed = true
+		}
+		result.Changes = append(result.Changes, change)
+	}
+
+	return result, nil
+}
+
+func (s *MemoryRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
+	_, err := s.CreateRecordVersion(ctx, record.ID, typedData(record.Data), WriteOptions{})
+	return err
+}
+
+func (s *MemoryRecordService) CreateRecordVersion(ctx context.Context, id int, data map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	data, changes := splitCreateData(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A deleted record can be created again; its history carries on.
+	current, err := s.latestRecordVersion(id)
+	switch {
+	case err == ErrRecordDoesNotExist:
+		current = entity.RecordVersion{ID: id}
+	case err != nil:
+		return entity.RecordVersion{}, err
+	case !current.Deleted:
+		return entity.RecordVersion{}, ErrRecordAlreadyExists
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	return s.appendRecordVersion(current, entity.RecordVersion{Data: data, Changes: changes}, opts)
+}
+
+func (s *MemoryRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	recordVersion, err := s.UpdateRecordVersion(ctx, id, TypedUpdates(updates), WriteOptions{})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	data, err := stringifyData(recordVersion.Data)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{ID: id, Data: data}, nil
+}
+
+func (s *MemoryRecordService) UpdateRecordVersion(ctx context.Context, id int, updates map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.latestLiveRecordVersion(id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := opts.checkNotBackdated(current); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	data := current.Data
+	applyUpdates(data, updates)
+
+	return s.appendRecordVersion(current, entity.RecordVersion{Data: data, Changes: updates}, opts)
+}
+
+func (s *MemoryRecordService) RevertRecord(ctx context.Context, id int, toVersion int, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+	if toVersion <= 0 {
+		return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.latestLiveRecordVersion(id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	versions := s.records[id]
+	if toVersion > len(versions) || versions[toVersion-1].meta.Deleted {
+		return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
+	}
+	target, err := versions[toVersion-1].recordVersion()
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	return s.appendRecordVersion(current, entity.RecordVersion{
+		Data:              target.Data,
+		Changes:           changesBetween(current.Data, target.Data),
+		RevertedToVersion: &toVersion,
+	}, opts)
+}
+
+func (s *MemoryRecordService) DeleteRecord(ctx context.Context, id int) error {
+	_, err := s.DeleteRecordVersion(ctx, id, WriteOptions{})
+	return err
+}
+
+func (s *MemoryRecordService) DeleteRecordVersion(ctx context.Context, id int, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.latestLiveRecordVersion(id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	return s.appendRecordVersion(current, entity.RecordVersion{
+		Data:    map[string]interface{}{},
+		Changes: changesBetween(current.Data, map[string]interface{}{}),
+		Deleted: true,
+	}, opts)
+}
+
+func (s *MemoryRecordService) RestoreRecord(ctx context.Context, id int, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.latestRecordVersion(id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if !current.Deleted {
+		return entity.RecordVersion{}, ErrRecordNotDeleted
+	}
+	if err := opts.checkExpectedVersion(current.Version); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	var target entity.RecordVersion
+	versions := s.records[id]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !versions[i].meta.Deleted {
+			if target, err = versions[i].recordVersion(); err != nil {
+				return entity.RecordVersion{}, err
+			}
+			break
+		}
+	}
+
+	return s.appendRecordVersion(current, entity.RecordVersion{
+		Data:              target.Data,
+		Changes:           changesBetween(current.Data, target.Data),
+		RevertedToVersion: &target.Version,
+	}, opts)
+}
+
+// latestRecordVersion reads the latest version of a record, which may be a
+// tombstone. The caller holds the lock.
+func (s *MemoryRecordService) latestRecordVersion(id int) (entity.RecordVersion, error) {
+	versions := s.records[id]
+	if len(versions) == 0 {
+		return entity.RecordVersion{}, ErrRecordDoesNotExist
+	}
+	return versions[len(versions)-1].recordVersion()
+}
+
+// latestLiveRecordVersion is latestRecordVersion, treating a deleted record as missing.
+func (s *MemoryRecordService) latestLiveRecordVersion(id int) (entity.RecordVersion, error) {
+	recordVersion, err := s.latestRecordVersion(id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if recordVersion.Deleted {
+		return entity.RecordVersion{}, ErrRecordDoesNotExist
+	}
+	return recordVersion, nil
+}
+
+// appendRecordVersion stores next as the version following current, filling
+// in the version number, timestamps and effective range like
+// DBRecordService.appendRecordVersion. The caller holds the write lock.
+func (s *MemoryRecordService) appendRecordVersion(current entity.RecordVersion, next entity.RecordVersion, opts WriteOptions) (entity.RecordVersion, error) {
+	next, err := nextVersion(current, next, opts)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	dataJSON, changesJSON, err := encodeVersionJSON(next)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	stored := memoryVersion{meta: next, dataJSON: dataJSON, changesJSON: changesJSON}
+	stored.meta.Data = nil
+	stored.meta.Changes = nil
+	if next.EffectiveToMS != nil {
+		effectiveToMS := *next.EffectiveToMS
+		stored.meta.EffectiveToMS = &effectiveToMS
+	}
+	if next.RevertedToVersion != nil {
+		revertedToVersion := *next.RevertedToVersion
+		stored.meta.RevertedToVersion = &revertedToVersion
+	}
+	s.records[current.ID] = append(s.records[current.ID], stored)
+
+	return next, nil
+}
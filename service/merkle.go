@@ -0,0 +1,59 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// The Merkle tree follows RFC 6962: leaves and nodes are hashed with
+// different prefixes so one can never pass for the other.
+
+func merkleLeafHash(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte{0x00}, data...))
+	return sum[:]
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	node := make([]byte, 0, 1+len(left)+len(right))
+	node = append(node, 0x01)
+	node = append(node, left...)
+	node = append(node, right...)
+	sum := sha256.Sum256(node)
+	return sum[:]
+}
+
+// merkleSplit is the largest power of two smaller than n, for n > 1.
+func merkleSplit(n int64) int64 {
+	k := int64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// merkleVerifyInclusion checks an audit path for the leaf at index in a tree
+// of size leaves, as in RFC 9162 section 2.1.3.2.
+func merkleVerifyInclusion(index, size int64, leaf []byte, path [][]byte, root []byte) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	hash := leaf
+	for _, sibling := range path {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = merkleNodeHash(sibling, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = merkleNodeHash(hash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(hash, root)
+}
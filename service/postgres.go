@@ -0,0 +1,839 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// postgresRecordVersionColumns is the column list scanned by scanPostgresRecordVersion.
+var postgresRecordVersionColumns = postgresRecordVersionColumnList(true, true)
+
+// postgresRecordVersionColumnList is recordVersionColumnList for PostgresRecordService.
+func postgresRecordVersionColumnList(data, changes bool) string {
+	dataColumn, changesColumn := `'{}'::jsonb`, `NULL::jsonb`
+	if data {
+		dataColumn = `data`
+	}
+	if changes {
+		changesColumn = `changes`
+	}
+	return `record_id, version, lower(recorded), lower(effective), upper(effective), ` + dataColumn + `, ` + changesColumn + `, reverted_to_version, deleted, actor, reason`
+}
+
+// PostgresRecordService stores records in PostgreSQL with the same semantics
+// as DBRecordService for everything in VersionedRecordService. Each version's
+// recorded and effective periods are tstzrange columns, and an exclusion
+// constraint keeps the recorded periods of a record's versions from
+// overlapping, so exactly one version is current at any time. It has a single
+// collection and none of the optional services, but unlike DBRecordService
+// any number of server processes can share one database.
+type PostgresRecordService struct {
+	db *sql.DB
+}
+
+func NewPostgresRecordService(dsn string) (*PostgresRecordService, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("dsn is required")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := initPostgresSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &PostgresRecordService{db: db}, nil
+}
+
+func (s *PostgresRecordService) Close() error {
+	return s.db.Close()
+}
+
+// postgresRecordVersionsDefinition is the body of the CREATE TABLE statement
+// for record_versions.
+const postgresRecordVersionsDefinition = `
+			record_id           BIGINT NOT NULL,
+			version             INTEGER NOT NULL,
+			-- When we learned about a version, up to when the next one was recorded.
+			recorded            TSTZRANGE NOT NULL,
+			-- When the version was true in the real world.
+			effective           TSTZRANGE NOT NULL,
+			data                JSONB NOT NULL,
+			changes             JSONB NOT NULL DEFAULT '{}',
+			reverted_to_version INTEGER,
+			deleted             BOOLEAN NOT NULL DEFAULT FALSE,
+			actor               TEXT NOT NULL DEFAULT '',
+			reason              TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (record_id, version),
+			CONSTRAINT record_versions_recorded_check CHECK (NOT isempty(recorded) AND NOT lower_inf(recorded)),
+			CONSTRAINT record_versions_effective_check CHECK (NOT isempty(effective) AND NOT lower_inf(effective)),
+			CONSTRAINT record_versions_recorded_excl EXCLUDE USING gist (record_id WITH =, recorded WITH &&)
+`
+
+func initPostgresSchema(db *sql.DB) error {
+	// Every statement is safe to run against an existing database, so this
+	// also migrates one created by an older server.
+	for _, statement := range []string{
+		// Lets the exclusion constraint compare record_id with =.
+		`CREATE EXTENSION IF NOT EXISTS btree_gist`,
+		`CREATE TABLE IF NOT EXISTS record_versions (` + postgresRecordVersionsDefinition + `)`,
+		// Columns added after the table was first created, as in initSchema.
+		// Versions written before effective time existed took effect when
+		// they were recorded.
+		`ALTER TABLE record_versions ADD COLUMN IF NOT EXISTS effective TSTZRANGE`,
+		`UPDATE record_versions SET effective = tstzrange(lower(recorded), NULL) WHERE effective IS NULL`,
+		`ALTER TABLE record_versions ALTER COLUMN effective SET NOT NULL`,
+		`ALTER TABLE record_versions ADD COLUMN IF NOT EXISTS changes JSONB NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE record_versions ADD COLUMN IF NOT EXISTS reverted_to_version INTEGER`,
+		`ALTER TABLE record_versions ADD COLUMN IF NOT EXISTS deleted BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE record_versions ADD COLUMN IF NOT EXISTS actor TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE record_versions ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT ''`,
+		// Serves listing the current version of every record.
+		`CREATE INDEX IF NOT EXISTS idx_record_versions_current ON record_versions (record_id) WHERE upper_inf(recorded)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanPostgresRecordVersion scans a row selected with postgresRecordVersionColumns.
+func scanPostgresRecordVersion(row rowScanner) (entity.RecordVersion, error) {
+	var recordVersion entity.RecordVersion
+	var (
+		createdAt     time.Time
+		effectiveFrom time.Time
+		effectiveTo   sql.NullTime
+		dataJSON      string
+		changesJSON   sql.NullString
+		revertedTo    sql.NullInt64
+	)
+	if err := row.Scan(
+		&recordVersion.ID,
+		&recordVersion.Version,
+		&createdAt,
+		&effectiveFrom,
+		&effectiveTo,
+		&dataJSON,
+		&changesJSON,
+		&revertedTo,
+		&recordVersion.Deleted,
+		&recordVersion.Actor,
+		&recordVersion.Reason,
+	); err != nil {
+		return entity.RecordVersion{}, err
+	}
+	recordVersion.CreatedAtMS = createdAt.UnixMilli()
+	recordVersion.EffectiveFromMS = effectiveFrom.UnixMilli()
+	if effectiveTo.Valid {
+		effectiveToMS := effectiveTo.Time.UnixMilli()
+		recordVersion.EffectiveToMS = &effectiveToMS
+	}
+
+	data, err := decodeJSONObject(dataJSON)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	recordVersion.Data = data
+
+	if revertedTo.Valid {
+		revertedToVersion := int(revertedTo.Int64)
+		recordVersion.RevertedToVersion = &revertedToVersion
+	}
+	if changesJSON.Valid {
+		changes, err := decodeJSONObject(changesJSON.String)
+		if err != nil {
+			return entity.RecordVersion{}, err
+		}
+		recordVersion.Changes = changes
+	}
+
+	return recordVersion, nil
+}
+
+// postgresTime converts a millisecond timestamp for a timestamptz parameter.
+func postgresTime(ms int64) time.Time {
+	return time.UnixMilli(ms).UTC()
+}
+
+// postgresArgs numbers query parameters as they are added.
+type postgresArgs []interface{}
+
+// add appends a parameter and returns its placeholder.
+func (a *postgresArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+func (s *PostgresRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	recordVersion, err := s.GetLatestRecordVersion(ctx, id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	data, err := stringifyData(recordVersion.Data)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{ID: id, Data: data}, nil
+}
+
+func (s *PostgresRecordService) GetLatestRecordVersion(ctx context.Context, id int) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	row := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+postgresRecordVersionColumns+` FROM record_versions WHERE record_id = $1 ORDER BY version DESC LIMIT 1`,
+		id,
+	)
+	return scanLivePostgresRecordVersion(row)
+}
+
+func (s *PostgresRecordService) GetRecordVersionAt(ctx context.Context, id int, atMS int64) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	// The exclusion constraint guarantees at most one version's recorded
+	// period contains atMS.
+	row := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+postgresRecordVersionColumns+` FROM record_versions WHERE record_id = $1 AND recorded @> $2::timestamptz`,
+		id,
+		postgresTime(atMS),
+	)
+	return scanLivePostgresRecordVersion(row)
+}
+
+func (s *PostgresRecordService) GetRecordVersionAsOf(ctx context.Context, id int, validAtMS int64, asOfMS int64) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	// Of the versions recorded by asOfMS whose effective range covers
+	// validAtMS, the most recently recorded one wins.
+	row := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+postgresRecordVersionColumns+`
+		 FROM record_versions
+		 WHERE record_id = $1
+		   AND lower(recorded) <= $2::timestamptz
+		   AND effective @> $3::timestamptz
+		 ORDER BY lower(recorded) DESC, version DESC
+		 LIMIT 1`,
+		id,
+		postgresTime(asOfMS),
+		postgresTime(validAtMS),
+	)
+	return scanLivePostgresRecordVersion(row)
+}
+
+// scanLivePostgresRecordVersion scans a single version, treating a missing
+// row or a tombstone as a missing record.
+func scanLivePostgresRecordVersion(row *sql.Row) (entity.RecordVersion, error) {
+	recordVersion, err := scanPostgresRecordVersion(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.RecordVersion{}, ErrRecordDoesNotExist
+		}
+		return entity.RecordVersion{}, err
+	}
+
+	if recordVersion.Deleted {
+		return entity.RecordVersion{}, ErrRecordDoesNotExist
+	}
+
+	return recordVersion, nil
+}
+
+func (s *PostgresRecordService) GetRecordVersion(ctx context.Context, id int, version int) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+	if version <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	row := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+postgresRecordVersionColumns+` FROM record_versions WHERE record_id = $1 AND version = $2`,
+		id,
+		version,
+	)
+	recordVersion, err := scanPostgresRecordVersion(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
+		}
+		return entity.RecordVersion{}, err
+	}
+
+	return recordVersion, nil
+}
+
+func (s *PostgresRecordService) ListRecordVersions(ctx context.Context, id int, opts ListVersionsOptions) (entity.RecordVersions, error) {
+	if id <= 0 {
+		return entity.RecordVersions{}, ErrRecordIDInvalid
+	}
+	if opts.Limit < 0 || opts.AfterVersion < 0 {
+		return entity.RecordVersions{}, ErrListOptionsInvalid
+	}
+
+	// Skip reading the JSON columns the caller did not ask for.
+	columns := postgresRecordVersionColumnList(!opts.OmitData, opts.IncludeChanges)
+
+	var args postgresArgs
+	query := `SELECT ` + columns + ` FROM record_versions WHERE record_id = ` + args.add(id)
+	if opts.AfterVersion > 0 {
+		if opts.Descending {
+			query += ` AND version < ` + args.add(opts.AfterVersion)
+		} else {
+			query += ` AND version > ` + args.add(opts.AfterVersion)
+		}
+	}
+	if opts.FromMS != nil {
+		query += ` AND lower(recorded) >= ` + args.add(postgresTime(*opts.FromMS)) + `::timestamptz`
+	}
+	if opts.ToMS != nil {
+		query += ` AND lower(recorded) < ` + args.add(postgresTime(*opts.ToMS)) + `::timestamptz`
+	}
+	if opts.Descending {
+		query += ` ORDER BY version DESC`
+	} else {
+		query += ` ORDER BY version ASC`
+	}
+	if opts.Limit > 0 {
+		// One extra row tells us whether there is a next page.
+		query += ` LIMIT ` + args.add(opts.Limit+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entity.RecordVersions{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	result := entity.RecordVersions{ID: id, Versions: []entity.RecordVersionInfo{}}
+	for rows.Next() {
+		recordVersion, err := scanPostgresRecordVersion(rows)
+		if err != nil {
+			return entity.RecordVersions{}, err
+		}
+		info := entity.RecordVersionInfo{
+			Version:           recordVersion.Version,
+			CreatedAtMS:       recordVersion.CreatedAtMS,
+			EffectiveFromMS:   recordVersion.EffectiveFromMS,
+			EffectiveToMS:     recordVersion.EffectiveToMS,
+			Data:              recordVersion.Data,
+			Changes:           recordVersion.Changes,
+			RevertedToVersion: recordVersion.RevertedToVersion,
+			Deleted:           recordVersion.Deleted,
+			Actor:             recordVersion.Actor,
+			Reason:            recordVersion.Reason,
+		}
+		if opts.OmitData {
+			info.Data = nil
+		}
+		result.Versions = append(result.Versions, info)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.RecordVersions{}, err
+	}
+
+	if opts.Limit > 0 && len(result.Versions) > opts.Limit {
+		result.Versions = result.Versions[:opts.Limit]
+		nextCursor := result.Versions[opts.Limit-1].Version
+		result.NextCursor = &nextCursor
+	}
+
+	// An empty page is only an error if the record has no versions at all.
+	if len(result.Versions) == 0 {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM record_versions WHERE record_id = $1)`, id).Scan(&exists); err != nil {
+			return entity.RecordVersions{}, err
+		}
+		if !exists {
+			return entity.RecordVersions{}, ErrRecordDoesNotExist
+		}
+	}
+
+	return result, nil
+}
+
+func (s *PostgresRecordService) ListRecords(ctx context.Context, opts ListRecordsOptions) (entity.RecordList, error) {
+	if opts.Limit < 0 || opts.AfterID < 0 {
+		return entity.RecordList{}, ErrListOptionsInvalid
+	}
+
+	var args postgresArgs
+	query := postgresCurrentVersionsQuery(&args, opts.AtMS)
+	query += ` AND record_id > ` + args.add(opts.AfterID)
+	for key, value := range opts.Where {
+		if _, err := jsonPath(key); err != nil {
+			return entity.RecordList{}, err
+		}
+		// Values compare as text, the way the v1 API renders them, as in
+		// DBRecordService: strings as-is, everything else as JSON, so 5.0
+		// does not match 5. jsonb does not keep the JSON text of objects and
+		// arrays, so those also match a value with the same structure.
+		field := `(data -> ` + args.add(key) + `::text)`
+		condition := `CASE jsonb_typeof(` + field + `) WHEN 'string' THEN ` + field + ` #>> '{}' ELSE ` + field + `::text END = ` + args.add(value)
+		if trimmed := strings.TrimSpace(value); json.Valid([]byte(value)) && (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) {
+			condition = `(` + condition + ` OR ` + field + ` = ` + args.add(value) + `::jsonb)`
+		}
+		query += ` AND ` + condition
+	}
+	query += ` ORDER BY record_id ASC`
+	if opts.Limit > 0 {
+		// One extra row tells us whether there is a next page.
+		query += ` LIMIT ` + args.add(opts.Limit+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entity.RecordList{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	result := entity.RecordList{Records: []entity.RecordVersion{}}
+	for rows.Next() {
+		recordVersion, err := scanPostgresRecordVersion(rows)
+		if err != nil {
+			return entity.RecordList{}, err
+		}
+		result.Records = append(result.Records, recordVersion)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.RecordList{}, err
+	}
+
+	if opts.Limit > 0 && len(result.Records) > opts.Limit {
+		result.Records = result.Records[:opts.Limit]
+		nextCursor := result.Records[opts.Limit-1].ID
+		result.NextCursor = &nextCursor
+	}
+
+	return result, nil
+}
+
+func (s *PostgresRecordService) SnapshotAt(ctx context.Context, atMS int64, fn func(entity.RecordVersion) error) error {
+	// A single statement sees a single snapshot, so every record stays at
+	// the same point in time even if writes land while it is streaming.
+	var args postgresArgs
+	query := postgresCurrentVersionsQuery(&args, &atMS)
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY record_id ASC`, args...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		recordVersion, err := scanPostgresRecordVersion(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(recordVersion); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// postgresCurrentVersionsQuery selects every live record at the version that
+// was current at atMS (or now, if nil). Callers may append further AND clauses.
+func postgresCurrentVersionsQuery(args *postgresArgs, atMS *int64) string {
+	current := `upper_inf(recorded)`
+	if atMS != nil {
+		current = `recorded @> ` + args.add(postgresTime(*atMS)) + `::timestamptz`
+	}
+	return `SELECT ` + postgresRecordVersionColumns + ` FROM record_versions WHERE ` + current + ` AND NOT deleted`
+}
+
+// DiffRecordVersions compares two versions of a record fetched through GetRecordVersion.
+func (s *PostgresRecordService) DiffRecordVersions(ctx context.Context, id int, fromVersion int, toVersion int) (entity.RecordDiff, error) {
+	from, err := s.GetRecordVersion(ctx, id, fromVersion)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+	to, err := s.GetRecordVersion(ctx, id, toVersion)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+
+	return diffRecordVersions(from, to), nil
+}
+
+func (s *PostgresRecordService) FieldHistory(ctx context.Context, id int, key string) (entity.FieldHistory, error) {
+	if id <= 0 {
+		return entity.FieldHistory{}, ErrRecordIDInvalid
+	}
+	if _, err := jsonPath(key); err != nil {
+		return entity.FieldHistory{}, err
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT version, lower(recorded), lower(effective), actor, reason, data -> $2::text
+		 FROM record_versions
+		 WHERE record_id = $1
+		 ORDER BY version ASC`,
+		id,
+		key,
+	)
+	if err != nil {
+		return entity.FieldHistory{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	result := entity.FieldHistory{ID: id, Key: key, Changes: []entity.FieldChange{}}
+	found := false
+	var previous sql.NullString
+	for rows.Next() {
+		found = true
+
+		var (
+			change        entity.FieldChange
+			createdAt     time.Time
+			effectiveFrom time.Time
+			value         sql.NullString
+		)
+		if err := rows.Scan(&change.Version, &createdAt, &effectiveFrom, &change.Actor, &change.Reason, &value); err != nil {
+			return entity.FieldHistory{}, err
+		}
+
+		// JSONB renders values in a canonical form, so equal values compare
+		// equal as text. Only versions where the key was set, changed or
+		// removed are reported.
+		if value == previous {
+			continue
+		}
+		previous = value
+
+		change.CreatedAtMS = createdAt.UnixMilli()
+		change.EffectiveFromMS = effectiveFrom.UnixMilli()
+		if value.Valid {
+			if change.Value, err = decodeJSONValue(value.String); err != nil {
+				return entity.FieldHistory{}, err
+			}
+		} else {
+			change.Removed = true
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.FieldHistory{}, err
+	}
+
+	if !found {
+		return entity.FieldHistory{}, ErrRecordDoesNotExist
+	}
+
+	return result, nil
+}
+
+func (s *PostgresRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
+	_, err := s.CreateRecordVersion(ctx, record.ID, typedData(record.Data), WriteOptions{})
+	return err
+}
+
+func (s *PostgresRecordService) CreateRecordVersion(ctx context.Context, id int, data map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	data, changes := splitCreateData(data)
+
+	return s.writeRecordVersion(ctx, id, func(tx *sql.Tx) (entity.RecordVersion, error) {
+		// A deleted record can be created again; its history carries on.
+		current, err := s.latestRecordVersion(ctx, tx, id)
+		switch {
+		case err == ErrRecordDoesNotExist:
+			current = entity.RecordVersion{ID: id}
+		case err != nil:
+			return entity.RecordVersion{}, err
+		case !current.Deleted:
+			return entity.RecordVersion{}, ErrRecordAlreadyExists
+		}
+		if err := opts.checkExpectedVersion(current.Version); err != nil {
+			return entity.RecordVersion{}, err
+		}
+
+		return s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{Data: data, Changes: changes}, opts)
+	})
+}
+
+func (s *PostgresRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	recordVersion, err := s.UpdateRecordVersion(ctx, id, TypedUpdates(updates), WriteOptions{})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	data, err := stringifyData(recordVersion.Data)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{ID: id, Data: data}, nil
+}
+
+func (s *PostgresRecordService) UpdateRecordVersion(ctx context.Context, id int, updates map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	return s.writeRecordVersion(ctx, id, func(tx *sql.Tx) (entity.RecordVersion, error) {
+		current, err := s.latestLiveRecordVersion(ctx, tx, id)
+		if err != nil {
+			return entity.RecordVersion{}, err
+		}
+		if err := opts.checkExpectedVersion(current.Version); err != nil {
+			return entity.RecordVersion{}, err
+		}
+		if err := opts.checkNotBackdated(current); err != nil {
+			return entity.RecordVersion{}, err
+		}
+
+		data := current.Data
+		applyUpdates(data, updates)
+
+		return s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{Data: data, Changes: updates}, opts)
+	})
+}
+
+func (s *PostgresRecordService) RevertRecord(ctx context.Context, id int, toVersion int, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+	if toVersion <= 0 {
+		return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
+	}
+
+	return s.writeRecordVersion(ctx, id, func(tx *sql.Tx) (entity.RecordVersion, error) {
+		current, err := s.latestLiveRecordVersion(ctx, tx, id)
+		if err != nil {
+			return entity.RecordVersion{}, err
+		}
+		if err := opts.checkExpectedVersion(current.Version); err != nil {
+			return entity.RecordVersion{}, err
+		}
+
+		row := tx.QueryRowContext(
+			ctx,
+			`SELECT `+postgresRecordVersionColumns+` FROM record_versions WHERE record_id = $1 AND version = $2`,
+			id,
+			toVersion,
+		)
+		target, err := scanPostgresRecordVersion(row)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
+			}
+			return entity.RecordVersion{}, err
+		}
+		if target.Deleted {
+			return entity.RecordVersion{}, ErrRecordVersionDoesNotExist
+		}
+
+		return s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{
+			Data:              target.Data,
+			Changes:           changesBetween(current.Data, target.Data),
+			RevertedToVersion: &toVersion,
+		}, opts)
+	})
+}
+
+func (s *PostgresRecordService) DeleteRecord(ctx context.Context, id int) error {
+	_, err := s.DeleteRecordVersion(ctx, id, WriteOptions{})
+	return err
+}
+
+func (s *PostgresRecordService) DeleteRecordVersion(ctx context.Context, id int, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	return s.writeRecordVersion(ctx, id, func(tx *sql.Tx) (entity.RecordVersion, error) {
+		current, err := s.latestLiveRecordVersion(ctx, tx, id)
+		if err != nil {
+			return entity.RecordVersion{}, err
+		}
+		if err := opts.checkExpectedVersion(current.Version); err != nil {
+			return entity.RecordVersion{}, err
+		}
+
+		return s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{
+			Data:    map[string]interface{}{},
+			Changes: changesBetween(current.Data, map[string]interface{}{}),
+			Deleted: true,
+		}, opts)
+	})
+}
+
+func (s *PostgresRecordService) RestoreRecord(ctx context.Context, id int, opts WriteOptions) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+
+	return s.writeRecordVersion(ctx, id, func(tx *sql.Tx) (entity.RecordVersion, error) {
+		current, err := s.latestRecordVersion(ctx, tx, id)
+		if err != nil {
+			return entity.RecordVersion{}, err
+		}
+		if !current.Deleted {
+			return entity.RecordVersion{}, ErrRecordNotDeleted
+		}
+		if err := opts.checkExpectedVersion(current.Version); err != nil {
+			return entity.RecordVersion{}, err
+		}
+
+		row := tx.QueryRowContext(
+			ctx,
+			`SELECT `+postgresRecordVersionColumns+` FROM record_versions WHERE record_id = $1 AND NOT deleted ORDER BY version DESC LIMIT 1`,
+			id,
+		)
+		target, err := scanPostgresRecordVersion(row)
+		if err != nil {
+			return entity.RecordVersion{}, err
+		}
+
+		return s.appendRecordVersion(ctx, tx, current, entity.RecordVersion{
+			Data:              target.Data,
+			Changes:           changesBetween(current.Data, target.Data),
+			RevertedToVersion: &target.Version,
+		}, opts)
+	})
+}
+
+// writeRecordVersion runs write in a transaction that holds a lock on the
+// record, so writers in other processes wait their turn instead of racing
+// for the next version number.
+func (s *PostgresRecordService) writeRecordVersion(ctx context.Context, id int, write func(tx *sql.Tx) (entity.RecordVersion, error)) (entity.RecordVersion, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, id); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	recordVersion, err := write(tx)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			return entity.RecordVersion{}, ErrRecordAlreadyExists
+		}
+		return entity.RecordVersion{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	return recordVersion, nil
+}
+
+// latestRecordVersion reads the latest version of a record inside tx,
+// which may be a tombstone.
+func (s *PostgresRecordService) latestRecordVersion(ctx context.Context, tx *sql.Tx, id int) (entity.RecordVersion, error) {
+	row := tx.QueryRowContext(
+		ctx,
+		`SELECT `+postgresRecordVersionColumns+` FROM record_versions WHERE record_id = $1 ORDER BY version DESC LIMIT 1`,
+		id,
+	)
+	recordVersion, err := scanPostgresRecordVersion(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.RecordVersion{}, ErrRecordDoesNotExist
+		}
+		return entity.RecordVersion{}, err
+	}
+	return recordVersion, nil
+}
+
+// latestLiveRecordVersion is latestRecordVersion, treating a deleted record as missing.
+func (s *PostgresRecordService) latestLiveRecordVersion(ctx context.Context, tx *sql.Tx, id int) (entity.RecordVersion, error) {
+	recordVersion, err := s.latestRecordVersion(ctx, tx, id)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if recordVersion.Deleted {
+		return entity.RecordVersion{}, ErrRecordDoesNotExist
+	}
+	return recordVersion, nil
+}
+
+// appendRecordVersion writes next as the version following current, filling
+// in the version number, timestamps and effective range like
+// DBRecordService.appendRecordVersion. current's recorded period is closed
+// where next's begins.
+func (s *PostgresRecordService) appendRecordVersion(ctx context.Context, tx *sql.Tx, current entity.RecordVersion, next entity.RecordVersion, opts WriteOptions) (entity.RecordVersion, error) {
+	next, err := nextVersion(current, next, opts)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	dataJSON, changesJSON, err := encodeVersionJSON(next)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	var effectiveTo interface{}
+	if next.EffectiveToMS != nil {
+		effectiveTo = postgresTime(*next.EffectiveToMS)
+	}
+	var revertedTo interface{}
+	if next.RevertedToVersion != nil {
+		revertedTo = *next.RevertedToVersion
+	}
+
+	if current.Version > 0 {
+		if _, err := tx.ExecContext(
+			ctx,
+			`UPDATE record_versions SET recorded = tstzrange(lower(recorded), $3::timestamptz, '[)') WHERE record_id = $1 AND version = $2`,
+			current.ID,
+			current.Version,
+			postgresTime(next.CreatedAtMS),
+		); err != nil {
+			return entity.RecordVersion{}, err
+		}
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO record_versions (record_id, version, recorded, effective, data, changes, reverted_to_version, deleted, actor, reason)
+		 VALUES ($1, $2, tstzrange($3::timestamptz, NULL, '[)'), tstzrange($4::timestamptz, $5::timestamptz, '[)'), $6::jsonb, $7::jsonb, $8, $9, $10, $11)`,
+		next.ID,
+		next.Version,
+		postgresTime(next.CreatedAtMS),
+		postgresTime(next.EffectiveFromMS),
+		effectiveTo,
+		dataJSON,
+		changesJSON,
+		revertedTo,
+		next.Deleted,
+		next.Actor,
+		next.Reason,
+	); err != nil {
+		return entity.RecordVersion{}, err
+	}
+	return next, nil
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/rainbowmga/timetravel/entity"
 )
@@ -11,6 +12,12 @@ var ErrRecordDoesNotExist = errors.New("record with that id does not exist")
 var ErrRecordIDInvalid = errors.New("record id must >= 0")
 var ErrRecordAlreadyExists = errors.New("record already exists")
 var ErrRecordVersionDoesNotExist = errors.New("record version does not exist")
+var ErrEffectiveRangeInvalid = errors.New("effective_to must be after effective_from")
+var ErrBackdatedUpdate = errors.New("an update cannot take effect before the latest version does")
+var ErrVersionConflict = errors.New("record has moved past the expected version")
+var ErrRecordNotDeleted = errors.New("record is not deleted")
+var ErrListOptionsInvalid = errors.New("limit and cursor must be >= 0")
+var ErrDataKeyInvalid = errors.New("data keys must be non-empty and must not contain quotes or backslashes")
 
 // Implements method to get, create, and update record data.
 type RecordService interface {
@@ -28,6 +35,11 @@ type RecordService interface {
 	//
 	// UpdateRecord will error if id <= 0 or the record does not exist with that id.
 	UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error)
+
+	// DeleteRecord appends a tombstone version, after which the record no longer exists.
+	//
+	// DeleteRecord will error if id <= 0 or the record does not exist with that id.
+	DeleteRecord(ctx context.Context, id int) error
 }
 
 // VersionedRecordService adds access to historical versions of a record.
@@ -36,5 +48,169 @@ type VersionedRecordService interface {
 	GetLatestRecordVersion(ctx context.Context, id int) (entity.RecordVersion, error)
 	GetRecordVersionAt(ctx context.Context, id int, atMS int64) (entity.RecordVersion, error)
 	GetRecordVersion(ctx context.Context, id int, version int) (entity.RecordVersion, error)
-	ListRecordVersions(ctx context.Context, id int) (entity.RecordVersions, error)
+	ListRecordVersions(ctx context.Context, id int, opts ListVersionsOptions) (entity.RecordVersions, error)
+
+	// GetRecordVersionAsOf answers "what was true at validAtMS, as we knew it
+	// at asOfMS": of the versions recorded by asOfMS whose effective range
+	// covers validAtMS, the most recently recorded one is returned.
+	GetRecordVersionAsOf(ctx context.Context, id int, validAtMS int64, asOfMS int64) (entity.RecordVersion, error)
+
+	// ListRecords lists records across the whole store, each at its latest
+	// version (or the version current at opts.AtMS), ordered by id.
+	ListRecords(ctx context.Context, opts ListRecordsOptions) (entity.RecordList, error)
+
+	// SnapshotAt streams every record as it was at atMS to fn, in id order,
+	// from a single consistent read. fn must not call back into the service.
+	SnapshotAt(ctx context.Context, atMS int64, fn func(entity.RecordVersion) error) error
+
+	// DiffRecordVersions reports the keys added, removed and changed between two versions.
+	DiffRecordVersions(ctx context.Context, id int, fromVersion int, toVersion int) (entity.RecordDiff, error)
+
+	// RevertRecord appends a new version whose data equals toVersion's,
+	// leaving the versions in between in the history.
+	RevertRecord(ctx context.Context, id int, toVersion int, opts WriteOptions) (entity.RecordVersion, error)
+
+	// DeleteRecordVersion is DeleteRecord, returning the tombstone it wrote.
+	DeleteRecordVersion(ctx context.Context, id int, opts WriteOptions) (entity.RecordVersion, error)
+
+	// RestoreRecord brings a deleted record back as a new version carrying
+	// the data it had before it was deleted.
+	RestoreRecord(ctx context.Context, id int, opts WriteOptions) (entity.RecordVersion, error)
+
+	// FieldHistory lists the versions in which a single key was set,
+	// changed or removed, oldest first.
+	FieldHistory(ctx context.Context, id int, key string) (entity.FieldHistory, error)
+
+	// CreateRecordVersion is CreateRecord, returning the version it wrote.
+	// Unlike CreateRecord, data values may be any JSON value; nil values are skipped.
+	CreateRecordVersion(ctx context.Context, id int, data map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error)
+
+	// UpdateRecordVersion is UpdateRecord, returning the version it wrote.
+	// Unlike UpdateRecord, update values may be any JSON value; nil deletes the key.
+	UpdateRecordVersion(ctx context.Context, id int, updates map[string]interface{}, opts WriteOptions) (entity.RecordVersion, error)
+}
+
+// ListVersionsOptions controls what ListRecordVersions returns.
+type ListVersionsOptions struct {
+	// IncludeChanges adds the patch each version was written from.
+	IncludeChanges bool
+
+	// OmitData leaves out each version's data, returning just the index.
+	OmitData bool
+
+	// Limit caps the number of versions returned; 0 means no limit. When
+	// more versions remain, the result's NextCursor is set.
+	Limit int
+
+	// AfterVersion resumes a listing from a previous NextCursor.
+	AfterVersion int
+
+	// Descending lists the newest version first.
+	Descending bool
+
+	// FromMS and ToMS restrict the listing to versions recorded in [FromMS, ToMS).
+	FromMS *int64
+	ToMS   *int64
+}
+
+// WriteOptions carries caller-supplied metadata for a new version.
+type WriteOptions struct {
+	// EffectiveFromMS is when the change took effect in the real world.
+	// Defaults to the time the version is recorded.
+	EffectiveFromMS *int64
+
+	// EffectiveToMS optionally ends the period the change is in effect for.
+	EffectiveToMS *int64
+
+	// ExpectedVersion, when set, makes the write conditional: it fails with
+	// ErrVersionConflict unless the record's latest version equals it.
+	// A create expects version 0.
+	ExpectedVersion *int
+
+	// Actor identifies who made the change.
+	Actor string
+
+	// Reason is a free-text explanation or source of the change,
+	// e.g. "policyholder portal" or "agent phone call".
+	Reason string
+
+	// Set for the writes of a transaction, which all share one
+	// created_at_ms and transaction id.
+	createdAtMS   int64
+	transactionID int
+}
+
+// checkExpectedVersion enforces ExpectedVersion against the latest version.
+func (o WriteOptions) checkExpectedVersion(currentVersion int) error {
+	if o.ExpectedVersion != nil && *o.ExpectedVersion != currentVersion {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// checkNotBackdated rejects a partial update that takes effect before the
+// latest version did. Its updates would be merged onto data that includes
+// changes which only took effect later.
+func (o WriteOptions) checkNotBackdated(current entity.RecordVersion) error {
+	if o.EffectiveFromMS != nil && *o.EffectiveFromMS < current.EffectiveFromMS {
+		return ErrBackdatedUpdate
+	}
+	return nil
+}
+
+// effectiveRange resolves the effective range of a version recorded at createdAtMS.
+func (o WriteOptions) effectiveRange(createdAtMS int64) (int64, *int64, error) {
+	effectiveFromMS := createdAtMS
+	if o.EffectiveFromMS != nil {
+		effectiveFromMS = *o.EffectiveFromMS
+	}
+	if o.EffectiveToMS != nil && *o.EffectiveToMS <= effectiveFromMS {
+		return 0, nil, ErrEffectiveRangeInvalid
+	}
+	return effectiveFromMS, o.EffectiveToMS, nil
+}
+
+// splitCreateData splits what a create was given into the version's data and
+// change set. The change set is every key it was given, nulled ones
+// included; only the keys it set become data.
+func splitCreateData(given map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	changes := make(map[string]interface{}, len(given))
+	for key, value := range given {
+		changes[key] = value
+	}
+	data := make(map[string]interface{}, len(changes))
+	for key, value := range changes {
+		if value != nil {
+			data[key] = value
+		}
+	}
+	return data, changes
+}
+
+// nextVersion fills in everything about next, the version to be written
+// after current, that does not depend on the backend: its number, when it
+// was recorded and took effect, and who made it and why. It is recorded at
+// the time a transaction picked, or else now, kept after current.
+func nextVersion(current entity.RecordVersion, next entity.RecordVersion, opts WriteOptions) (entity.RecordVersion, error) {
+	createdAtMS := opts.createdAtMS
+	if createdAtMS == 0 {
+		createdAtMS = time.Now().UTC().UnixMilli()
+		if createdAtMS <= current.CreatedAtMS {
+			createdAtMS = current.CreatedAtMS + 1
+		}
+	}
+	effectiveFromMS, effectiveToMS, err := opts.effectiveRange(createdAtMS)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+
+	next.ID = current.ID
+	next.Version = current.Version + 1
+	next.CreatedAtMS = createdAtMS
+	next.EffectiveFromMS = effectiveFromMS
+	next.EffectiveToMS = effectiveToMS
+	next.Actor = opts.Actor
+	next.Reason = opts.Reason
+	next.TransactionID = opts.transactionID
+	return next, nil
 }
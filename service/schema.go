@@ -0,0 +1,334 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+var ErrSchemaNameInvalid = errors.New("schema names may only contain letters, digits, '.', '_' and '-'")
+var ErrSchemaInvalid = errors.New("schema is not a valid JSON Schema")
+var ErrSchemaDoesNotExist = errors.New("schema does not exist")
+var ErrValidationFailed = errors.New("record data does not match its schema")
+
+// ValidationError is returned when a write's data fails the record's schema.
+// errors.Is(err, ErrValidationFailed) reports true for it.
+type ValidationError struct {
+	Fields []entity.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return ErrValidationFailed.Error()
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidationFailed
+}
+
+// SchemaService stores versioned JSON Schemas and the records they apply to.
+// Once a record has a schema, every write whose resulting data fails it is
+// rejected with a *ValidationError.
+type SchemaService interface {
+	// RegisterSchema stores schema as the next version of name.
+	RegisterSchema(ctx context.Context, name string, schema json.RawMessage) (entity.Schema, error)
+	// GetSchema returns a version of name, or its latest version when version is 0.
+	GetSchema(ctx context.Context, name string, version int) (entity.Schema, error)
+	// GetRecordSchema returns the schema a record is validated against: its
+	// own, or else its collection's.
+	GetRecordSchema(ctx context.Context, id int) (entity.RecordSchema, error)
+	// AssignSchema validates a record's writes against a version of name from
+	// now on; a version of 0 follows the latest version. The record's current
+	// data, if any, must already satisfy it.
+	AssignSchema(ctx context.Context, assignment entity.RecordSchema) (entity.RecordSchema, error)
+}
+
+var schemaNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func (s *DBRecordService) RegisterSchema(ctx context.Context, name string, schema json.RawMessage) (entity.Schema, error) {
+	if !schemaNamePattern.MatchString(name) {
+		return entity.Schema{}, ErrSchemaNameInvalid
+	}
+	if _, err := compileSchema(name, 0, schema); err != nil {
+		return entity.Schema{}, err
+	}
+
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, schema); err != nil {
+		return entity.Schema{}, ErrSchemaInvalid
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Schema{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result := entity.Schema{
+		Name:        name,
+		CreatedAtMS: time.Now().UTC().UnixMilli(),
+		Schema:      json.RawMessage(compacted.Bytes()),
+	}
+	if err := tx.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(MAX(version), 0) + 1 FROM schemas WHERE name = ?`,
+		name,
+	).Scan(&result.Version); err != nil {
+		return entity.Schema{}, err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO schemas (name, version, schema_json, created_at_ms) VALUES (?, ?, ?, ?)`,
+		result.Name,
+		result.Version,
+		string(result.Schema),
+		result.CreatedAtMS,
+	); err != nil {
+		return entity.Schema{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.Schema{}, err
+	}
+
+	return result, nil
+}
+
+func (s *DBRecordService) GetSchema(ctx context.Context, name string, version int) (entity.Schema, error) {
+	return getSchema(ctx, s.db, name, version)
+}
+
+func (s *DBRecordService) GetRecordSchema(ctx context.Context, id int) (entity.RecordSchema, error) {
+	if id <= 0 {
+		return entity.RecordSchema{}, ErrRecordIDInvalid
+	}
+
+	assignment, err := s.recordSchemaAssignment(ctx, s.db, id)
+	if err != nil {
+		return entity.RecordSchema{}, err
+	}
+	if assignment.Name == "" {
+		return entity.RecordSchema{}, ErrSchemaDoesNotExist
+	}
+	return assignment, nil
+}
+
+func (s *DBRecordService) AssignSchema(ctx context.Context, assignment entity.RecordSchema) (entity.RecordSchema, error) {
+	if assignment.ID <= 0 {
+		return entity.RecordSchema{}, ErrRecordIDInvalid
+	}
+	if assignment.Version < 0 {
+		return entity.RecordSchema{}, ErrSchemaDoesNotExist
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.RecordSchema{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO record_schemas (collection, record_id, schema_name, schema_version) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (collection, record_id) DO UPDATE SET schema_name = excluded.schema_name, schema_version = excluded.schema_version`,
+		s.collection,
+		assignment.ID,
+		assignment.Name,
+		assignment.Version,
+	); err != nil {
+		return entity.RecordSchema{}, err
+	}
+
+	// A schema can be assigned before the record is created, but an existing
+	// record has to satisfy it already.
+	current, err := s.latestLiveRecordVersion(ctx, tx, assignment.ID)
+	switch {
+	case err == ErrRecordDoesNotExist:
+		if _, err := s.recordSchema(ctx, tx, assignment.ID); err != nil {
+			return entity.RecordSchema{}, err
+		}
+	case err != nil:
+		return entity.RecordSchema{}, err
+	default:
+		if err := s.validateRecordData(ctx, tx, assignment.ID, current.Data); err != nil {
+			return entity.RecordSchema{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return entity.RecordSchema{}, err
+	}
+	return assignment, nil
+}
+
+func getSchema(ctx context.Context, db queryRower, name string, version int) (entity.Schema, error) {
+	var row *sql.Row
+	if version == 0 {
+		row = db.QueryRowContext(
+			ctx,
+			`SELECT name, version, schema_json, created_at_ms FROM schemas WHERE name = ? ORDER BY version DESC LIMIT 1`,
+			name,
+		)
+	} else {
+		row = db.QueryRowContext(
+			ctx,
+			`SELECT name, version, schema_json, created_at_ms FROM schemas WHERE name = ? AND version = ?`,
+			name,
+			version,
+		)
+	}
+
+	var result entity.Schema
+	var schemaJSON string
+	err := row.Scan(&result.Name, &result.Version, &schemaJSON, &result.CreatedAtMS)
+	if err == sql.ErrNoRows {
+		return entity.Schema{}, ErrSchemaDoesNotExist
+	}
+	if err != nil {
+		return entity.Schema{}, err
+	}
+	result.Schema = json.RawMessage(schemaJSON)
+	return result, nil
+}
+
+// recordSchemaAssignment looks up the schema a record is validated against,
+// falling back to its collection's. The name is empty when there is none.
+func (s *DBRecordService) recordSchemaAssignment(ctx context.Context, db queryRower, id int) (entity.RecordSchema, error) {
+	assignment := entity.RecordSchema{ID: id}
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT schema_name, schema_version FROM record_schemas WHERE collection = ? AND record_id = ?`,
+		s.collection,
+		id,
+	).Scan(&assignment.Name, &assignment.Version)
+	if err != sql.ErrNoRows {
+		return assignment, err
+	}
+
+	collection, err := s.getCollection(ctx, db, s.collection)
+	if err != nil {
+		return entity.RecordSchema{}, err
+	}
+	assignment.Name = collection.SchemaName
+	assignment.Version = collection.SchemaVersion
+	return assignment, nil
+}
+
+// recordSchema compiles the schema a record is validated against, or returns
+// nil when it has none.
+func (s *DBRecordService) recordSchema(ctx context.Context, db queryRower, id int) (*jsonschema.Schema, error) {
+	assignment, err := s.recordSchemaAssignment(ctx, db, id)
+	if err != nil || assignment.Name == "" {
+		return nil, err
+	}
+
+	schema, err := getSchema(ctx, db, assignment.Name, assignment.Version)
+	if err != nil {
+		return nil, err
+	}
+	return s.schemas.get(schema)
+}
+
+// validateRecordData checks data against the schema assigned to a record.
+func (s *DBRecordService) validateRecordData(ctx context.Context, db queryRower, id int, data map[string]interface{}) error {
+	schema, err := s.recordSchema(ctx, db, id)
+	if err != nil || schema == nil {
+		return err
+	}
+	return validateData(schema, data)
+}
+
+func validateData(schema *jsonschema.Schema, data map[string]interface{}) error {
+	// Round trip through JSON so the validator only sees JSON types.
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	instance, err := decodeJSONValue(string(dataJSON))
+	if err != nil {
+		return err
+	}
+
+	err = schema.Validate(instance)
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		return &ValidationError{Fields: fieldErrors(validationErr)}
+	}
+	return err
+}
+
+// fieldErrors flattens the leaves of a validation error tree, which are the
+// errors that say what is actually wrong.
+func fieldErrors(err *jsonschema.ValidationError) []entity.FieldError {
+	var fields []entity.FieldError
+	var walk func(*jsonschema.ValidationError)
+	walk = func(err *jsonschema.ValidationError) {
+		if len(err.Causes) == 0 {
+			fields = append(fields, entity.FieldError{Field: err.InstanceLocation, Message: err.Message})
+			return
+		}
+		for _, cause := range err.Causes {
+			walk(cause)
+		}
+	}
+	walk(err)
+
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return fields
+}
+
+func compileSchema(name string, version int, schema json.RawMessage) (*jsonschema.Schema, error) {
+	url := fmt.Sprintf("timetravel:///schemas/%s/%d.json", name, version)
+	compiler := jsonschema.NewCompiler()
+	// A schema may only refer to itself. The default loaders would follow a
+	// "$ref" to file:// or http(s):// URLs and read local files or the network.
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("schemas may not load %s", url)
+	}
+	if err := compiler.AddResource(url, bytes.NewReader(schema)); err != nil {
+		return nil, ErrSchemaInvalid
+	}
+	compiled, err := compiler.Compile(url)
+	if err != nil {
+		return nil, ErrSchemaInvalid
+	}
+	return compiled, nil
+}
+
+// schemaCache keeps compiled schemas by name and version. Registered versions
+// never change, so entries never go stale.
+type schemaCache struct {
+	mu      sync.Mutex
+	schemas map[string]*jsonschema.Schema
+}
+
+func newSchemaCache() *schemaCache {
+	return &schemaCache{schemas: map[string]*jsonschema.Schema{}}
+}
+
+func (c *schemaCache) get(schema entity.Schema) (*jsonschema.Schema, error) {
+	key := fmt.Sprintf("%s@%d", schema.Name, schema.Version)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if compiled, ok := c.schemas[key]; ok {
+		return compiled, nil
+	}
+	compiled, err := compileSchema(schema.Name, schema.Version, schema.Schema)
+	if err != nil {
+		return nil, err
+	}
+	c.schemas[key] = compiled
+	return compiled, nil
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+var ErrTransactionEmpty = errors.New("a transaction needs at least one operation")
+var ErrOperationInvalid = errors.New("operations need an op of create, update, delete or put and a positive id")
+var ErrTransactionDoesNotExist = errors.New("transaction does not exist")
+
+// OperationError reports which operation made a transaction fail. It
+// unwraps to the operation's error.
+type OperationError struct {
+	Index int
+	Err   error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// TransactionService writes to many records at once: either every operation
+// is applied or none is.
+type TransactionService interface {
+	// ApplyTransaction applies ops in order. Operations without a collection
+	// apply to this service's collection. The actor, reason and effective
+	// range of opts apply to every operation; expected versions are given per
+	// operation instead.
+	ApplyTransaction(ctx context.Context, ops []entity.Operation, opts WriteOptions) (entity.Transaction, error)
+	GetTransaction(ctx context.Context, id int) (entity.Transaction, error)
+}
+
+func (s *DBRecordService) ApplyTransaction(ctx context.Context, ops []entity.Operation, opts WriteOptions) (entity.Transaction, error) {
+	if len(ops) == 0 {
+		return entity.Transaction{}, ErrTransactionEmpty
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	services, createdAtMS, err := s.prepareOperations(ctx, tx, ops, nil)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+
+	result, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO transactions (created_at_ms, actor, reason) VALUES (?, ?, ?)`,
+		createdAtMS,
+		sql.NullString{String: opts.Actor, Valid: opts.Actor != ""},
+		sql.NullString{String: opts.Reason, Valid: opts.Reason != ""},
+	)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	transactionID, err := result.LastInsertId()
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	opts.createdAtMS = createdAtMS
+	opts.transactionID = int(transactionID)
+
+	versions, err := applyOperations(ctx, tx, ops, services, opts, nil)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return entity.Transaction{
+		ID:          int(transactionID),
+		CreatedAtMS: createdAtMS,
+		Actor:       opts.Actor,
+		Reason:      opts.Reason,
+		Versions:    versions,
+	}, nil
+}
+
+// prepareOperations checks ops and returns the service for each one's
+// collection, along with a created_at_ms for all of their versions that
+// comes after the latest version of every record they touch. When skip is
+// set, an operation whose error it accepts gets no service instead of
+// failing them all.
+func (s *DBRecordService) prepareOperations(ctx context.Context, tx *sql.Tx, ops []entity.Operation, skip func(index int, err error) bool) ([]*DBRecordService, int64, error) {
+	createdAtMS := time.Now().UTC().UnixMilli()
+	services := make([]*DBRecordService, len(ops))
+	for i, op := range ops {
+		scoped, err := s.operationService(ctx, tx, op)
+		if err != nil {
+			if skip != nil && skip(i, err) {
+				continue
+			}
+			return nil, 0, &OperationError{Index: i, Err: err}
+		}
+		services[i] = scoped
+
+		current, err := scoped.latestRecordVersion(ctx, tx, op.ID)
+		switch {
+		case err == ErrRecordDoesNotExist:
+		case err != nil:
+			return nil, 0, err
+		case current.CreatedAtMS >= createdAtMS:
+			createdAtMS = current.CreatedAtMS + 1
+		}
+	}
+	return services, createdAtMS, nil
+}
+
+// applyOperations writes ops inside tx, in order, skipping those without
+// a service. When skip is set, an operation whose error it accepts is
+// undone and left out instead of failing them all.
+func applyOperations(ctx context.Context, tx *sql.Tx, ops []entity.Operation, services []*DBRecordService, opts WriteOptions, skip func(index int, err error) bool) ([]entity.TransactionVersion, error) {
+	versions := make([]entity.TransactionVersion, 0, len(ops))
+	for i, op := range ops {
+		if services[i] == nil {
+			continue
+		}
+		opOpts := opts
+		opOpts.ExpectedVersion = op.ExpectedVersion
+
+		// A savepoint lets a skipped operation be undone on its own.
+		if skip != nil {
+			if _, err := tx.ExecContext(ctx, `SAVEPOINT operation`); err != nil {
+				return nil, err
+			}
+		}
+
+		var recordVersion entity.RecordVersion
+		var err error
+		switch op.Op {
+		case entity.OperationCreate:
+			recordVersion, err = services[i].createRecordVersion(ctx, tx, op.ID, op.Data, opOpts)
+		case entity.OperationUpdate:
+			recordVersion, err = services[i].updateRecordVersion(ctx, tx, op.ID, op.Data, opOpts)
+		case entity.OperationDelete:
+			recordVersion, err = services[i].deleteRecordVersion(ctx, tx, op.ID, opOpts)
+		case entity.OperationPut:
+			recordVersion, err = services[i].putRecordVersion(ctx, tx, op.ID, op.Data, opOpts)
+		}
+		if err != nil && skip != nil && skip(i, err) {
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO operation`); err != nil {
+				return nil, err
+			}
+			if _, err := tx.ExecContext(ctx, `RELEASE operation`); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, &OperationError{Index: i, Err: err}
+		}
+		if skip != nil {
+			if _, err := tx.ExecContext(ctx, `RELEASE operation`); err != nil {
+				return nil, err
+			}
+		}
+		versions = append(versions, entity.TransactionVersion{
+			Collection:    services[i].collection,
+			RecordVersion: recordVersion,
+		})
+	}
+	return versions, nil
+}
+
+func (s *DBRecordService) GetTransaction(ctx context.Context, id int) (entity.Transaction, error) {
+	if id <= 0 {
+		return entity.Transaction{}, ErrTransactionDoesNotExist
+	}
+
+	transaction := entity.Transaction{ID: id, Versions: []entity.TransactionVersion{}}
+	var actor, reason sql.NullString
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT created_at_ms, actor, reason FROM transactions WHERE id = ?`,
+		id,
+	).Scan(&transaction.CreatedAtMS, &actor, &reason)
+	if err == sql.ErrNoRows {
+		return entity.Transaction{}, ErrTransactionDoesNotExist
+	}
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	transaction.Actor = actor.String
+	transaction.Reason = reason.String
+
+	// Versions were inserted in operation order.
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT collection, `+recordVersionColumns+` FROM record_versions WHERE transaction_id = ? ORDER BY rowid ASC`,
+		id,
+	)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var version entity.TransactionVersion
+		version.RecordVersion, err = scanRecordVersion(collectionScanner{row: rows, collection: &version.Collection})
+		if err != nil {
+			return entity.Transaction{}, err
+		}
+		transaction.Versions = append(transaction.Versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.Transaction{}, err
+	}
+
+	return transaction, nil
+}
+
+// operationService checks an operation and returns the service for its collection.
+func (s *DBRecordService) operationService(ctx context.Context, tx *sql.Tx, op entity.Operation) (*DBRecordService, error) {
+	switch op.Op {
+	case entity.OperationCreate, entity.OperationUpdate, entity.OperationDelete, entity.OperationPut:
+	default:
+		return nil, ErrOperationInvalid
+	}
+	if op.ID <= 0 {
+		return nil, ErrOperationInvalid
+	}
+
+	if op.Collection == "" || op.Collection == s.collection {
+		return s, nil
+	}
+	if _, err := s.getCollection(ctx, tx, op.Collection); err != nil {
+		return nil, err
+	}
+	return s.inCollection(op.Collection), nil
+}
+
+// collectionScanner scans a row selected with a leading collection column
+// followed by recordVersionColumns.
+type collectionScanner struct {
+	row        rowScanner
+	collection *string
+}
+
+func (c collectionScanner) Scan(dest ...interface{}) error {
+	return c.row.Scan(append([]interface{}{c.collection}, dest...)...)
+}
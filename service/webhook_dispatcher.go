@@ -0,0 +1,325 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// The headers sent with every webhook request. The signature is
+// "sha256=" followed by the hex HMAC-SHA256 of the body keyed by the
+// webhook's secret; see WebhookSignature.
+const (
+	WebhookDeliveryHeader  = "X-Timetravel-Delivery"
+	WebhookSeqHeader       = "X-Timetravel-Seq"
+	WebhookSignatureHeader = "X-Timetravel-Signature"
+)
+
+// WebhookSignature is the signature header value for a webhook request body.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcherOptions tunes a WebhookDispatcher. Zero values take the defaults.
+type WebhookDispatcherOptions struct {
+	// Client sends the requests; it defaults to one with a 10 second timeout
+	// that refuses to connect to loopback, link-local, private or
+	// unspecified addresses, and ignores proxy settings so that check
+	// applies to the webhook itself.
+	Client *http.Client
+	// MaxAttempts is how many times a delivery is tried before it is dead;
+	// it defaults to 8.
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt, doubling
+	// after every failure up to MaxBackoff. They default to 1s and 1h.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// PollInterval is how often an idle dispatcher looks for due deliveries;
+	// it defaults to 1s.
+	PollInterval time.Duration
+	// Concurrency is how many webhooks are sent to at once; it defaults to 8.
+	// Each webhook still gets one request at a time.
+	Concurrency int
+	// DeliveredRetention is how long delivered deliveries are kept before
+	// Run prunes them; it defaults to 7 days. Dead ones are kept until
+	// their webhook is deleted.
+	DeliveredRetention time.Duration
+	// OnError, when set, is told about errors reading or updating the outbox.
+	OnError func(error)
+}
+
+// WebhookDispatcher delivers the webhook outbox. Every delivery is retried
+// until the webhook answers with a 2xx status or the delivery runs out of
+// attempts. Deliveries to one webhook are made in the order their versions
+// were written, and a delivery that may have been sent but was not recorded
+// as delivered is sent again, so webhooks should ignore a seq they have
+// already seen.
+type WebhookDispatcher struct {
+	db   *sql.DB
+	opts WebhookDispatcherOptions
+}
+
+func NewWebhookDispatcher(s *DBRecordService, opts WebhookDispatcherOptions) *WebhookDispatcher {
+	if opts.Client == nil {
+		opts.Client = webhookClient(s.allowPrivateWebhooks)
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+	if opts.DeliveredRetention <= 0 {
+		opts.DeliveredRetention = 7 * 24 * time.Hour
+	}
+	return &WebhookDispatcher{db: s.db, opts: opts}
+}
+
+// webhookClient is the default WebhookDispatcherOptions.Client.
+func webhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = blockWebhookDial
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// webhookPruneInterval is how often Run prunes delivered deliveries.
+const webhookPruneInterval = time.Hour
+
+// Run delivers webhooks until ctx is done.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	var prunedAt time.Time
+	for {
+		if time.Since(prunedAt) >= webhookPruneInterval {
+			if _, err := d.PruneDelivered(ctx); err != nil && ctx.Err() == nil && d.opts.OnError != nil {
+				d.opts.OnError(err)
+			}
+			prunedAt = time.Now()
+		}
+
+		attempted, err := d.DispatchDue(ctx)
+		if err != nil && ctx.Err() == nil && d.opts.OnError != nil {
+			d.opts.OnError(err)
+		}
+		// Keep going while there is work; a webhook's next delivery may
+		// already be due.
+		if err == nil && attempted > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.opts.PollInterval):
+		}
+	}
+}
+
+// pendingDelivery is a delivery that is due, with what is needed to send it.
+type pendingDelivery struct {
+	id       int
+	seq      int64
+	attempts int
+	url      string
+	secret   string
+}
+
+// DispatchDue attempts the oldest pending delivery of every webhook whose
+// delivery is due, Concurrency webhooks at a time, and returns how many
+// attempts it recorded.
+func (d *WebhookDispatcher) DispatchDue(ctx context.Context) (int, error) {
+	nowMS := time.Now().UTC().UnixMilli()
+	rows, err := d.db.QueryContext(
+		ctx,
+		`SELECT d.id, d.seq, d.attempts, w.url, w.secret
+		 FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		 WHERE d.status = ? AND d.next_attempt_at_ms <= ?
+		   AND d.id = (SELECT MIN(p.id) FROM webhook_deliveries p WHERE p.webhook_id = d.webhook_id AND p.status = ?)
+		 ORDER BY d.id ASC`,
+		entity.WebhookDeliveryPending,
+		nowMS,
+		entity.WebhookDeliveryPending,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var due []pendingDelivery
+	for rows.Next() {
+		var delivery pendingDelivery
+		if err := rows.Scan(&delivery.id, &delivery.seq, &delivery.attempts, &delivery.url, &delivery.secret); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		due = append(due, delivery)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	// A slow webhook only holds up its own deliveries.
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		attempted int
+		firstErr  error
+	)
+	slots := make(chan struct{}, d.opts.Concurrency)
+	for _, delivery := range due {
+		delivery := delivery
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			err := d.attempt(ctx, delivery)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			attempted++
+		}()
+	}
+	wg.Wait()
+	return attempted, firstErr
+}
+
+// PruneDelivered deletes deliveries that were delivered longer than
+// DeliveredRetention ago and returns how many it deleted.
+func (d *WebhookDispatcher) PruneDelivered(ctx context.Context) (int64, error) {
+	cutoffMS := time.Now().UTC().Add(-d.opts.DeliveredRetention).UnixMilli()
+	result, err := d.db.ExecContext(
+		ctx,
+		`DELETE FROM webhook_deliveries WHERE status = ? AND last_attempt_at_ms < ?`,
+		entity.WebhookDeliveryDelivered,
+		cutoffMS,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// attempt sends a delivery once and records the outcome.
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery pendingDelivery) error {
+	change, err := changeBySeq(ctx, d.db, delivery.seq)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	sendErr := d.send(ctx, delivery, body)
+	if ctx.Err() != nil {
+		// Shutting down is not the webhook's fault.
+		return ctx.Err()
+	}
+
+	nowMS := time.Now().UTC().UnixMilli()
+	attempts := delivery.attempts + 1
+	if sendErr == nil {
+		_, err = d.db.ExecContext(
+			ctx,
+			`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_attempt_at_ms = ?, last_error = NULL WHERE id = ?`,
+			entity.WebhookDeliveryDelivered,
+			attempts,
+			nowMS,
+			delivery.id,
+		)
+		return err
+	}
+
+	status := entity.WebhookDeliveryPending
+	if attempts >= d.opts.MaxAttempts {
+		status = entity.WebhookDeliveryDead
+	}
+	_, err = d.db.ExecContext(
+		ctx,
+		`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_attempt_at_ms = ?, last_error = ?, next_attempt_at_ms = ? WHERE id = ?`,
+		status,
+		attempts,
+		nowMS,
+		sendErr.Error(),
+		nowMS+d.backoff(attempts).Milliseconds(),
+		delivery.id,
+	)
+	return err
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, delivery pendingDelivery, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(delivery.id))
+	req.Header.Set(WebhookSeqHeader, strconv.FormatInt(delivery.seq, 10))
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(delivery.secret, body))
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// backoff is how long to wait after a delivery's nth failed attempt.
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	wait := d.opts.InitialBackoff
+	for i := 1; i < attempts && wait < d.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.opts.MaxBackoff {
+		wait = d.opts.MaxBackoff
+	}
+	return wait
+}
+
+func changeBySeq(ctx context.Context, db queryRower, seq int64) (entity.Change, error) {
+	var change entity.Change
+	row := db.QueryRowContext(ctx, `SELECT seq, collection, `+recordVersionColumns+` FROM record_versions WHERE seq = ?`, seq)
+	var err error
+	change.RecordVersion, err = scanRecordVersion(changeScanner{row: row, change: &change})
+	return change, err
+}
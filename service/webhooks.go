@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+var ErrWebhookURLInvalid = errors.New("webhook url must be an absolute http or https url")
+var ErrWebhookHostNotAllowed = errors.New("webhook url must not point at a loopback, link-local, private or unspecified address")
+var ErrWebhookDoesNotExist = errors.New("webhook does not exist")
+
+// WebhookService manages webhook subscribers. Every version written after a
+// webhook is created is queued for it in the same transaction as the write;
+// a WebhookDispatcher delivers the queue.
+type WebhookService interface {
+	// CreateWebhook subscribes a URL to every new version in a collection, or
+	// in every collection when none is given. A secret is generated when the
+	// webhook has none.
+	CreateWebhook(ctx context.Context, webhook entity.Webhook) (entity.Webhook, error)
+	// ListWebhooks returns every webhook, without its secret.
+	ListWebhooks(ctx context.Context) ([]entity.Webhook, error)
+	// DeleteWebhook removes a webhook and every delivery queued for it.
+	DeleteWebhook(ctx context.Context, id int) error
+	// ListDeadLetters returns a webhook's deliveries that ran out of
+	// attempts, oldest first.
+	ListDeadLetters(ctx context.Context, webhookID int) ([]entity.WebhookDelivery, error)
+}
+
+func (s *DBRecordService) CreateWebhook(ctx context.Context, webhook entity.Webhook) (entity.Webhook, error) {
+	parsed, err := url.Parse(webhook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return entity.Webhook{}, ErrWebhookURLInvalid
+	}
+	if !s.allowPrivateWebhooks {
+		if err := checkWebhookHost(ctx, parsed.Hostname()); err != nil {
+			return entity.Webhook{}, err
+		}
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return entity.Webhook{}, err
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if webhook.Collection != "" {
+		if _, err := s.getCollection(ctx, tx, webhook.Collection); err != nil {
+			return entity.Webhook{}, err
+		}
+	}
+
+	webhook.CreatedAtMS = time.Now().UTC().UnixMilli()
+	result, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO webhooks (url, secret, collection, created_at_ms) VALUES (?, ?, ?, ?)`,
+		webhook.URL,
+		webhook.Secret,
+		sql.NullString{String: webhook.Collection, Valid: webhook.Collection != ""},
+		webhook.CreatedAtMS,
+	)
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	webhook.ID = int(id)
+
+	if err := tx.Commit(); err != nil {
+		return entity.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (s *DBRecordService) ListWebhooks(ctx context.Context) ([]entity.Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, url, collection, created_at_ms FROM webhooks ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	webhooks := []entity.Webhook{}
+	for rows.Next() {
+		var webhook entity.Webhook
+		var collection sql.NullString
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &collection, &webhook.CreatedAtMS); err != nil {
+			return nil, err
+		}
+		webhook.Collection = collection.String
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *DBRecordService) DeleteWebhook(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebhookDoesNotExist
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *DBRecordService) ListDeadLetters(ctx context.Context, webhookID int) ([]entity.WebhookDelivery, error) {
+	var marker int
+	err := s.db.QueryRowContext(ctx, `SELECT 1 FROM webhooks WHERE id = ?`, webhookID).Scan(&marker)
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookDoesNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ? AND status = ? ORDER BY id ASC`,
+		webhookID,
+		entity.WebhookDeliveryDead,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	deliveries := []entity.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// enqueueWebhookDeliveries queues a version that was just inserted for every
+// webhook subscribed to its collection.
+func enqueueWebhookDeliveries(ctx context.Context, tx *sql.Tx, collection string, recordVersion entity.RecordVersion) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, seq, status, next_attempt_at_ms, created_at_ms)
+		 SELECT w.id, rv.seq, ?, ?, ?
+		 FROM webhooks w, record_versions rv
+		 WHERE rv.collection = ? AND rv.record_id = ? AND rv.version = ?
+		   AND (w.collection IS NULL OR w.collection = rv.collection)`,
+		entity.WebhookDeliveryPending,
+		recordVersion.CreatedAtMS,
+		recordVersion.CreatedAtMS,
+		collection,
+		recordVersion.ID,
+		recordVersion.Version,
+	)
+	return err
+}
+
+const webhookDeliveryColumns = "id, webhook_id, seq, status, attempts, last_error, last_attempt_at_ms, created_at_ms"
+
+func scanWebhookDelivery(row rowScanner) (entity.WebhookDelivery, error) {
+	var delivery entity.WebhookDelivery
+	var lastError sql.NullString
+	var lastAttemptAtMS sql.NullInt64
+	if err := row.Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.Seq,
+		&delivery.Status,
+		&delivery.Attempts,
+		&lastError,
+		&lastAttemptAtMS,
+		&delivery.CreatedAtMS,
+	); err != nil {
+		return entity.WebhookDelivery{}, err
+	}
+	delivery.LastError = lastError.String
+	delivery.LastAttemptAtMS = lastAttemptAtMS.Int64
+	return delivery, nil
+}
+
+// checkWebhookHost rejects a host that resolves to an address webhooks must
+// not be sent to. A host that does not resolve yet is let through; the
+// dispatcher checks every address it connects to anyway.
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if blockedWebhookIP(addr.IP) {
+			return ErrWebhookHostNotAllowed
+		}
+	}
+	return nil
+}
+
+// blockedWebhookIP reports whether ip is on the server's own host or
+// network: loopback, link-local (which includes cloud metadata endpoints),
+// private or unspecified.
+func blockedWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// blockWebhookDial is a net.Dialer Control that refuses to connect to a
+// blocked address, whatever the webhook's host resolved to at registration.
+func blockWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || blockedWebhookIP(ip) {
+		return fmt.Errorf("%w: %s", ErrWebhookHostNotAllowed, address)
+	}
+	return nil
+}